@@ -0,0 +1,393 @@
+/*******************************************************************************
+* FileName:  agent.go
+* Author: Victor
+* Date: 2019/08/28 10:15
+* Description: active check runtime: scheduling, buffering, sending, reload
+* Project: zabbix_agent
+*******************************************************************************/
+
+// Package agent runs the active checks: it collects every configured item
+// on its interval, buffers the values and sends them to the zabbix server.
+// The configuration can be replaced while running without losing values
+// that are still waiting to be sent.
+package agent
+
+import (
+	"../collector"
+	"../config"
+	"../pkg"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is reported by the agent.version item.
+const Version = "0.2.0"
+
+// maxBatch is the largest number of values sent in one request.
+const maxBatch = 250
+
+func init() {
+	collector.Register("agent.ping", func(params []string) (interface{}, error) {
+		return 1, nil
+	})
+	collector.Register("agent.version", func(params []string) (interface{}, error) {
+		return Version, nil
+	})
+	collector.Register("agent.log.dropped", func(params []string) (interface{}, error) {
+		return pkg.DroppedRecords(), nil
+	})
+}
+
+// Agent is a running active agent.
+type Agent struct {
+	load func() (*config.Config, error)
+
+	mu    sync.Mutex // protects conf and items
+	conf  *config.Config
+	items map[string]*item
+
+	buf      *buffer
+	batches  uint64           // number of batches sent, for correlating log records
+	logw     io.WriteCloser   // nil when logging to the console
+	logq     *pkg.AsyncWriter // nil when writing the log synchronously
+	control  net.Listener
+	stop     chan struct{}
+	stopOnce sync.Once
+	exitOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// restartKeys are the config keys a reload does not apply.
+var restartKeys = []string{
+	"agent.port",
+	"agent.controlsocket",
+	"agent.logtype",
+	"agent.logfile",
+	"agent.logsocket",
+	"agent.logfilesize",
+	"agent.logrotate",
+	"agent.logmaxbackups",
+	"agent.logmaxage",
+	"agent.logcompress",
+	"agent.logqueue",
+	"agent.logdrop",
+}
+
+type item struct {
+	key      string
+	interval time.Duration
+	next     time.Time
+	busy     bool // a collection is in progress
+}
+
+// New prepares an agent running conf. load is called to get the new
+// configuration on every reload.
+func New(conf *config.Config, load func() (*config.Config, error)) (*Agent, error) {
+	a := &Agent{
+		load:  load,
+		items: make(map[string]*item),
+		buf:   newBuffer(conf.Agent.BufferSize),
+		stop:  make(chan struct{}),
+	}
+	if err := a.apply(conf); err != nil {
+		return nil, err
+	}
+	a.openLog(conf)
+	if path := conf.Agent.BufferFile; path != "" {
+		if n, err := a.buf.load(path); err != nil {
+			pkg.Warnf("unsent values in %s not restored: %v", path, err)
+		} else if n > 0 {
+			pkg.Infof("%d unsent values restored from %s", n, path)
+		}
+	}
+	pkg.OnExit(a.exit)
+	return a, nil
+}
+
+// Config returns the configuration currently in use.
+func (a *Agent) Config() *config.Config {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.conf
+}
+
+// Run collects and sends values until Stop is called. Values still
+// buffered at that point get one last chance to be sent.
+func (a *Agent) Run() error {
+	if path := a.Config().Agent.ControlSocket; path != "" {
+		l, err := listenControl(path)
+		if err != nil {
+			return err
+		}
+		a.control = l
+		a.wg.Add(1)
+		go a.serveControl(l)
+	}
+	a.wg.Add(2)
+	go a.schedule()
+	go a.send()
+	pkg.Infof("agent %s started, sending to %s", Version, a.Config().ServerAddress())
+
+	<-a.stop
+	a.wg.Wait()
+	a.flush()
+	a.exit()
+	return nil
+}
+
+// exit stops the agent without waiting for its goroutines, saves the
+// values not sent yet to agent.bufferfile and closes the log. It is the
+// exit hook of the agent, run by pkg.Exit and at the end of Run.
+func (a *Agent) exit() {
+	a.exitOnce.Do(func() {
+		a.Stop()
+		n := a.buf.len()
+		path := a.Config().Agent.BufferFile
+		switch {
+		case n == 0:
+		case path == "":
+			pkg.Warnf("agent stopped with %d unsent values", n)
+		default:
+			if _, err := a.buf.save(path); err != nil {
+				pkg.Errorf("agent stopped with %d unsent values, not saved: %v", n, err)
+			} else {
+				pkg.Infof("agent stopped, %d unsent values saved to %s", n, path)
+			}
+		}
+		a.closeLog()
+	})
+}
+
+// Stop makes Run return.
+func (a *Agent) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+		if a.control != nil {
+			a.control.Close()
+		}
+	})
+}
+
+// Reload loads the configuration again and switches to it. If it cannot be
+// loaded or is invalid the running configuration is kept and the error is
+// logged and returned.
+func (a *Agent) Reload() error {
+	conf, err := a.load()
+	if err == nil {
+		err = a.apply(conf)
+	}
+	if err != nil {
+		pkg.Errorf("config reload rejected, keeping the running config: %v", err)
+		return err
+	}
+	for _, w := range conf.Warnings() {
+		pkg.Warnf("config: %s", w)
+	}
+	pkg.Infof("config reloaded, %d items, sending to %s", len(conf.Items), conf.ServerAddress())
+	return nil
+}
+
+// apply switches to conf. Everything that can fail is checked before the
+// first change so a bad config leaves the agent untouched.
+func (a *Agent) apply(conf *config.Config) error {
+	ups, err := collector.ParseUserParameters(conf.Agent.UserParameter)
+	if err != nil {
+		return err
+	}
+	for _, it := range conf.Items {
+		if _, _, err := collector.ParseKey(it.Key); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if old := a.conf; old != nil {
+		for _, key := range restartKeys {
+			if old.Value(key) != conf.Value(key) {
+				pkg.Warnf("%s only changes on restart, still using %s", key, old.Value(key))
+			}
+		}
+	}
+	a.conf = conf
+	collector.SetUserParameters(ups)
+	collector.SetRecover(conf.Agent.RecoverPanics)
+	collector.SetHostRoot(conf.Agent.HostRoot)
+	collector.SetRPMSource(conf.Agent.RPMCommand, conf.Agent.RPMFile)
+	setLogOptions(conf)
+	a.buf.resize(conf.Agent.BufferSize)
+
+	// Items keep their schedule across a reload: a changed interval counts
+	// from the last collection.
+	now := time.Now()
+	items := make(map[string]*item, len(conf.Items))
+	for _, it := range conf.Items {
+		interval := time.Duration(it.Interval) * time.Second
+		n := &item{key: it.Key, interval: interval, next: now}
+		if old, ok := a.items[it.Key]; ok {
+			n.next = old.next.Add(interval - old.interval)
+			n.busy = old.busy
+		}
+		items[it.Key] = n
+	}
+	a.items = items
+	return nil
+}
+
+// schedule starts the collection of every item that is due.
+func (a *Agent) schedule() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case now := <-ticker.C:
+			a.mu.Lock()
+			host := a.conf.Hostname()
+			timeout := time.Duration(a.conf.Agent.Timeout) * time.Second
+			for _, it := range a.items {
+				if it.busy || now.Before(it.next) {
+					continue
+				}
+				it.busy = true
+				it.next = now.Add(it.interval)
+				schedulerLog.Log(pkg.Ltrace, "due", "item", it.key, "next", it.next.Format("15:04:05"))
+				go a.check(it.key, host, timeout)
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+// check collects one item and queues its value.
+func (a *Agent) check(key, host string, timeout time.Duration) {
+	defer func() {
+		a.mu.Lock()
+		if it, ok := a.items[key]; ok {
+			it.busy = false
+		}
+		a.mu.Unlock()
+	}()
+	// The item goes with every record rather than into the context, so
+	// only records of the same item count as repeats.
+	l := collectorLog(key).With("host", host)
+	v, err := collector.Collect(key, timeout)
+	if pe, ok := err.(*collector.PanicError); ok {
+		l.Log(pkg.Lerror, "collector panicked", "item", key, "panic", pe.Value, "stack", string(pe.Stack))
+		return
+	}
+	if err != nil {
+		l.Log(pkg.Lwarn, "collect failed", "item", key, "err", err)
+		return
+	}
+	switch v.(type) {
+	case string, int, int64, uint64, float64:
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			l.Log(pkg.Lwarn, "collect failed", "item", key, "err", err)
+			return
+		}
+		v = string(b)
+	}
+	l.Log(pkg.Ltrace, "collected", "item", key, "value", v)
+	a.buf.push(pkg.MinorData{Host: host, Key: key, Value: v, Clock: int32(time.Now().Unix())})
+}
+
+// send flushes the buffer every agent.buffersend seconds.
+func (a *Agent) send() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case now := <-ticker.C:
+			every := time.Duration(a.Config().Agent.BufferSend) * time.Second
+			if now.Sub(last) >= every {
+				a.flush()
+				last = now
+			}
+		}
+	}
+}
+
+// flush sends buffered values in batches until the buffer is empty or the
+// server cannot be reached. Values are only removed once the server
+// answered, so nothing is lost while it is down unless the buffer fills
+// up, which is reported here.
+func (a *Agent) flush() {
+	if n := a.buf.takeDropped(); n > 0 {
+		senderLog.Log(pkg.Lwarn, "buffer full, oldest values dropped",
+			"dropped", n, "buffersize", a.Config().Agent.BufferSize)
+	}
+	for {
+		batch, last := a.buf.peek(maxBatch)
+		if len(batch) == 0 {
+			return
+		}
+		a.batches++
+		address := a.Config().ServerAddress()
+		l := senderLog.With("batch", a.batches, "server", address, "values", len(batch))
+		timeout := time.Duration(a.Config().Agent.Timeout) * time.Second
+		if err := sendBatch(l, address, batch, timeout); err != nil {
+			l.Log(pkg.Lwarn, "send failed", "err", err, "items", batchKeys(batch))
+			if _, ok := err.(rejectedError); !ok {
+				return
+			}
+		}
+		a.buf.remove(last)
+	}
+}
+
+// rejectedError is a response other than success: the server got the
+// values, so sending them again would not help.
+type rejectedError struct{ res pkg.ResData }
+
+func (e rejectedError) Error() string {
+	return fmt.Sprintf("server answered %q: %s", e.res.Response, e.res.Info)
+}
+
+// batchKeys lists the distinct item keys of batch.
+func batchKeys(batch []pkg.MinorData) string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, v := range batch {
+		if !seen[v.Key] {
+			seen[v.Key] = true
+			keys = append(keys, v.Key)
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+// sendBatch sends batch to the server at address within timeout, logging
+// to l.
+func sendBatch(l *pkg.Logger, address string, batch []pkg.MinorData, timeout time.Duration) error {
+	data, err := json.Marshal(pkg.MajorData{Request: "agent data", Data: batch})
+	if err != nil {
+		return err
+	}
+	res, err := pkg.DataSender(l, address, data, timeout)
+	if err != nil {
+		return err
+	}
+	var rd pkg.ResData
+	if err := json.Unmarshal([]byte(res), &rd); err != nil {
+		return fmt.Errorf("bad response %q: %v", res, err)
+	}
+	if rd.Response != "success" {
+		return rejectedError{rd}
+	}
+	l.Log(pkg.Ldebug, "sent", "info", rd.Info)
+	return nil
+}
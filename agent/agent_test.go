@@ -0,0 +1,299 @@
+package agent
+
+import (
+	"../config"
+	"../pkg"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// logBuffer collects the log of a test agent.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// testConfig returns a valid config logging every record to the console,
+// without a control socket, collecting keys every minute.
+func testConfig(keys ...string) *config.Config {
+	c := config.Default()
+	c.Agent.LogType = "console"
+	c.Agent.LogFile = ""
+	c.Agent.ControlSocket = ""
+	c.Agent.LogRepeat = 0
+	c.Agent.Timeout = 1
+	for _, key := range keys {
+		c.Items = append(c.Items, config.Item{Key: key, Interval: 60})
+	}
+	return c
+}
+
+// newTestAgent starts an agent on conf whose log goes to the returned
+// buffer. The log goes back to standard error by calling done.
+func newTestAgent(t *testing.T, conf *config.Config,
+	load func() (*config.Config, error)) (*Agent, *logBuffer, func()) {
+	t.Helper()
+	a, err := New(conf, load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &logBuffer{}
+	pkg.SetOutput(log)
+	return a, log, func() { pkg.SetOutput(os.Stderr) }
+}
+
+func TestReload(t *testing.T) {
+	next := testConfig("agent.ping", "agent.version")
+	a, log, done := newTestAgent(t, testConfig("agent.ping", "agent.version"),
+		func() (*config.Config, error) { return next, nil })
+	defer done()
+	ping := a.items["agent.ping"].next
+
+	next = testConfig("agent.ping", "agent.log.dropped")
+	next.Items[0].Interval = 120
+	next.Agent.Port = 10055
+	next.Agent.BufferSize = 2
+	if err := a.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if a.Config() != next || len(a.items) != 2 || a.items["agent.log.dropped"] == nil {
+		t.Fatalf("reload not applied: items %v", a.items)
+	}
+	if d := a.items["agent.ping"].next.Sub(ping); d != time.Minute {
+		t.Errorf("agent.ping moved by %v, want the minute its interval grew", d)
+	}
+	for i := 0; i < 3; i++ {
+		a.buf.push(value(i))
+	}
+	if n := a.buf.len(); n != 2 {
+		t.Errorf("%d values buffered, want the new buffersize 2", n)
+	}
+	if !strings.Contains(log.String(), "agent.port only changes on restart, still using 10050") {
+		t.Errorf("no warning about agent.port in\n%s", log)
+	}
+
+	// a config that does not load or apply leaves the running one alone
+	good := next
+	invalid := &config.ValidationError{Path: "conf.tml",
+		Problems: []string{"agent.timeout: 0 is out of range 1-30"}}
+	for name, load := range map[string]func() (*config.Config, error){
+		"invalid": func() (*config.Config, error) {
+			return testConfig("agent.ping"), invalid
+		},
+		"built-in user parameter": func() (*config.Config, error) {
+			c := testConfig("agent.ping")
+			c.Agent.UserParameter = []string{"agent.ping,echo 1"}
+			return c, nil
+		},
+		"bad item key": func() (*config.Config, error) {
+			return testConfig("agent.ping[", "system.uptime"), nil
+		},
+	} {
+		a.load = load
+		if err := a.Reload(); err == nil {
+			t.Errorf("%s: reload accepted", name)
+		}
+		if a.Config() != good || len(a.items) != 2 || a.items["agent.log.dropped"] == nil {
+			t.Errorf("%s: running config changed", name)
+		}
+	}
+	if n := strings.Count(log.String(), "config reload rejected, keeping the running config"); n != 3 {
+		t.Errorf("%d rejections logged, want 3, in\n%s", n, log)
+	}
+}
+
+// fakeServer is a zabbix server answering every request with response.
+type fakeServer struct {
+	l        net.Listener
+	mu       sync.Mutex
+	response string
+	requests []pkg.MajorData
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{l: l, response: "success"}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// serve reads one request on conn and answers it.
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(conn, header); err != nil || string(header[:5]) != "ZBXD\x01" {
+		return
+	}
+	data := make([]byte, binary.LittleEndian.Uint64(header[5:]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return
+	}
+	var req pkg.MajorData
+	if err := json.Unmarshal(data, &req); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	res, _ := json.Marshal(pkg.ResData{Response: s.response, Info: fmt.Sprintf("processed: %d", len(req.Data))})
+	s.mu.Unlock()
+	binary.LittleEndian.PutUint64(header[5:], uint64(len(res)))
+	conn.Write(append(header, res...))
+}
+
+// received returns the number of values of every request so far.
+func (s *fakeServer) received() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := make([]int, len(s.requests))
+	for i, req := range s.requests {
+		n[i] = len(req.Data)
+	}
+	return n
+}
+
+func (s *fakeServer) configure(c *config.Config) {
+	addr := s.l.Addr().(*net.TCPAddr)
+	c.Server.Ip, c.Server.Port = addr.IP.String(), addr.Port
+}
+
+func TestFlush(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.l.Close()
+	conf := testConfig()
+	conf.Agent.BufferSize = 1000
+	s.configure(conf)
+	a, log, done := newTestAgent(t, conf, nil)
+	defer done()
+
+	// sent in batches of maxBatch
+	for i := 0; i < 300; i++ {
+		a.buf.push(value(i))
+	}
+	a.flush()
+	if got := s.received(); len(got) != 2 || got[0] != maxBatch || got[1] != 300-maxBatch {
+		t.Errorf("sent %v, want batches of 250 and 50", got)
+	}
+	if n := a.buf.len(); n != 0 {
+		t.Errorf("%d values left after sending", n)
+	}
+
+	// a rejected batch is not sent again
+	s.mu.Lock()
+	s.response = "failed"
+	s.mu.Unlock()
+	a.buf.push(value(1))
+	a.flush()
+	if n := a.buf.len(); n != 0 || len(s.received()) != 3 {
+		t.Errorf("%d values left after a rejection, %d requests", n, len(s.received()))
+	}
+	if !strings.Contains(log.String(), `server answered \"failed\"`) {
+		t.Errorf("rejection not logged in\n%s", log)
+	}
+
+	// values are kept while the server is down, but for those beyond the
+	// buffer size
+	s.l.Close()
+	a.buf.resize(3)
+	for i := 0; i < 5; i++ {
+		a.buf.push(value(i))
+	}
+	a.flush()
+	if n := a.buf.len(); n != 3 {
+		t.Errorf("%d values kept while the server is down, want 3", n)
+	}
+	for _, want := range []string{
+		"buffer full, oldest values dropped component=sender dropped=2",
+		"send failed",
+	} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("no %q in\n%s", want, log)
+		}
+	}
+}
+
+// Run collects the items on their interval and sends their values.
+func TestRun(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.l.Close()
+	conf := testConfig("agent.ping", "agent.version")
+	conf.Agent.Hostname = "test-host"
+	conf.Agent.BufferSend = 1
+	s.configure(conf)
+	a, _, done := newTestAgent(t, conf, nil)
+	defer done()
+
+	errc := make(chan error, 1)
+	go func() { errc <- a.Run() }()
+	deadline := time.Now().Add(5 * time.Second)
+	for sum(s.received()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	a.Stop()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+
+	got := make(map[string]interface{})
+	s.mu.Lock()
+	for _, req := range s.requests {
+		if req.Request != "agent data" {
+			t.Errorf("request %q", req.Request)
+		}
+		for _, v := range req.Data {
+			if v.Host != "test-host" {
+				t.Errorf("%s sent for host %q", v.Key, v.Host)
+			}
+			got[v.Key] = v.Value
+		}
+	}
+	s.mu.Unlock()
+	if len(got) != 2 || got["agent.ping"] != float64(1) || got["agent.version"] != Version {
+		t.Errorf("sent %v", got)
+	}
+}
+
+func sum(list []int) int {
+	n := 0
+	for _, v := range list {
+		n += v
+	}
+	return n
+}
@@ -0,0 +1,139 @@
+/*******************************************************************************
+* FileName:  buffer.go
+* Author: Victor
+* Date: 2019/08/28 09:30
+* Description: bounded queue of values waiting to be sent
+* Project: zabbix_agent
+*******************************************************************************/
+package agent
+
+import (
+	"../pkg"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// buffer keeps collected values until the server accepted them. When it is
+// full the oldest value is dropped. Every value gets a sequence number so a
+// batch can be removed after sending even if values were pushed or dropped
+// in the meantime.
+type buffer struct {
+	mu      sync.Mutex
+	values  []pkg.MinorData
+	seq     []uint64
+	next    uint64
+	size    int
+	dropped uint64 // since the last call to takeDropped
+}
+
+func newBuffer(size int) *buffer {
+	return &buffer{size: size}
+}
+
+func (b *buffer) push(v pkg.MinorData) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.values = append(b.values, v)
+	b.seq = append(b.seq, b.next)
+	b.next++
+	b.trim()
+}
+
+// trim drops the oldest values beyond the buffer size. b.mu must be held.
+func (b *buffer) trim() {
+	if n := len(b.values) - b.size; n > 0 {
+		b.values = append(b.values[:0], b.values[n:]...)
+		b.seq = append(b.seq[:0], b.seq[n:]...)
+		b.dropped += uint64(n)
+	}
+}
+
+// peek returns a copy of up to n of the oldest values and the sequence
+// number of the last one.
+func (b *buffer) peek(n int) ([]pkg.MinorData, uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > len(b.values) {
+		n = len(b.values)
+	}
+	if n == 0 {
+		return nil, 0
+	}
+	batch := make([]pkg.MinorData, n)
+	copy(batch, b.values)
+	return batch, b.seq[n-1]
+}
+
+// remove drops every value up to and including sequence number last.
+func (b *buffer) remove(last uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for n < len(b.seq) && b.seq[n] <= last {
+		n++
+	}
+	b.values = append(b.values[:0], b.values[n:]...)
+	b.seq = append(b.seq[:0], b.seq[n:]...)
+}
+
+// resize changes the capacity, dropping the oldest values if it shrinks.
+func (b *buffer) resize(size int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.size = size
+	b.trim()
+}
+
+// save writes the buffered values to path, through a temporary file so a
+// crash cannot leave half a file behind, and returns their number.
+func (b *buffer) save(path string) (int, error) {
+	b.mu.Lock()
+	data, err := json.Marshal(b.values)
+	n := len(b.values)
+	b.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp, path)
+}
+
+// load queues the values saved at path and removes the file. A missing
+// file is not an error.
+func (b *buffer) load(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var values []pkg.MinorData
+	if err := json.Unmarshal(data, &values); err != nil {
+		return 0, err
+	}
+	for _, v := range values {
+		b.push(v)
+	}
+	return len(values), os.Remove(path)
+}
+
+// takeDropped returns the number of values dropped since its last call.
+func (b *buffer) takeDropped() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := b.dropped
+	b.dropped = 0
+	return n
+}
+
+func (b *buffer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.values)
+}
@@ -0,0 +1,98 @@
+package agent
+
+import (
+	"../pkg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// value returns the buffered value number i.
+func value(i int) pkg.MinorData {
+	return pkg.MinorData{Host: "test", Key: "agent.ping", Value: float64(i), Clock: int32(i)}
+}
+
+// values returns the clocks of batch, which number the values.
+func values(batch []pkg.MinorData) []int32 {
+	clocks := make([]int32, len(batch))
+	for i, v := range batch {
+		clocks[i] = v.Clock
+	}
+	return clocks
+}
+
+// A full buffer drops the oldest values and counts them until the sender
+// reports them.
+func TestBufferOverflow(t *testing.T) {
+	b := newBuffer(3)
+	for i := 1; i <= 5; i++ {
+		b.push(value(i))
+	}
+	if n := b.takeDropped(); n != 2 {
+		t.Errorf("dropped %d, want 2", n)
+	}
+	if n := b.takeDropped(); n != 0 {
+		t.Errorf("dropped %d on the second call, want 0", n)
+	}
+	batch, last := b.peek(10)
+	if got := values(batch); !reflect.DeepEqual(got, []int32{3, 4, 5}) {
+		t.Errorf("kept %v, want [3 4 5]", got)
+	}
+
+	// values pushed while a batch is sent stay, even when they push out
+	// values of the batch
+	b.push(value(6)) // drops 3
+	b.push(value(7)) // drops 4
+	b.remove(last)
+	if batch, _ := b.peek(10); !reflect.DeepEqual(values(batch), []int32{6, 7}) {
+		t.Errorf("after remove: %v, want [6 7]", values(batch))
+	}
+	b.push(value(8))
+	b.push(value(9)) // drops 6
+	batch, last = b.peek(2)
+	b.push(value(10)) // drops 7 of the batch being sent
+	b.remove(last)
+	if batch, _ := b.peek(10); !reflect.DeepEqual(values(batch), []int32{9, 10}) {
+		t.Errorf("after a drop: %v, want [9 10]", values(batch))
+	}
+
+	b.resize(1)
+	if batch, _ := b.peek(10); !reflect.DeepEqual(values(batch), []int32{10}) {
+		t.Errorf("after shrinking: %v, want [10]", values(batch))
+	}
+	if n := b.takeDropped(); n != 5 { // 3, 4, 6, 7 and 9
+		t.Errorf("dropped %d, want 5", n)
+	}
+}
+
+func TestBufferSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "buffer.json")
+
+	b := newBuffer(10)
+	for i := 1; i <= 3; i++ {
+		b.push(value(i))
+	}
+	if n, err := b.save(path); n != 3 || err != nil {
+		t.Fatalf("saved %d: %v", n, err)
+	}
+	b = newBuffer(2)
+	if n, err := b.load(path); n != 3 || err != nil {
+		t.Fatalf("loaded %d: %v", n, err)
+	}
+	if batch, _ := b.peek(10); !reflect.DeepEqual(batch, []pkg.MinorData{value(2), value(3)}) {
+		t.Errorf("loaded %v", batch)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("%s not removed after loading", path)
+	}
+	if n, err := b.load(path); n != 0 || err != nil {
+		t.Errorf("loading a missing file: %d, %v", n, err)
+	}
+}
@@ -0,0 +1,161 @@
+/*******************************************************************************
+* FileName:  control.go
+* Author: Victor
+* Date: 2019/08/28 15:50
+* Description: runtime control socket, the equivalent of zabbix_agentd -R
+* Project: zabbix_agent
+*******************************************************************************/
+package agent
+
+import (
+	"../config"
+	"../pkg"
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// controlTimeout bounds one exchange on the control socket.
+const controlTimeout = 10 * time.Second
+
+// commands are the runtime control commands. Each gets the text after an
+// optional '=' and returns the reply sent back to the client.
+var commands = map[string]func(a *Agent, arg string) (string, error){
+	"config_reload": func(a *Agent, arg string) (string, error) {
+		if err := a.Reload(); err != nil {
+			return "", err
+		}
+		return "config reloaded", nil
+	},
+	"log_level_increase": func(a *Agent, arg string) (string, error) {
+		return changeLogLevel(arg, -1)
+	},
+	"log_level_decrease": func(a *Agent, arg string) (string, error) {
+		return changeLogLevel(arg, +1)
+	},
+}
+
+func init() {
+	commands["reload"] = commands["config_reload"]
+	commands["help"] = func(a *Agent, arg string) (string, error) {
+		names := make([]string, 0, len(commands))
+		for name := range commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "commands: " + strings.Join(names, ", "), nil
+	}
+}
+
+// changeLogLevel moves the log level of component, or the global one if
+// component is empty, one step: towards trace for a negative step and
+// towards error for a positive one. It reports the new level, which lasts
+// until agent.loglevel and log.levels are applied again by a reload.
+func changeLogLevel(component string, step int) (string, error) {
+	l, what := pkg.With(), "log level"
+	if component != "" {
+		if !config.ValidLogComponent(component) {
+			return "", fmt.Errorf("unknown component %q, use %s or collector:<key name>",
+				component, strings.Join(config.LogComponents, ", "))
+		}
+		l, what = pkg.Named(component), "log level of "+component
+	}
+	old := l.Level()
+	level := old + pkg.Level(step)
+	if level < pkg.Ltrace || level > pkg.Lerror {
+		return "", fmt.Errorf("%s is already %s", what, strings.ToLower(old.String()))
+	}
+	l.SetLevel(level)
+	pkg.Warnf("%s changed from %s to %s", what, strings.ToLower(old.String()), strings.ToLower(level.String()))
+	return what + " set to " + strings.ToLower(level.String()), nil
+}
+
+// listenControl opens the control socket at path, replacing a stale
+// socket file left by an agent that did not exit cleanly.
+func listenControl(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("control socket %s is in use by another agent", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("control socket: %v", err)
+	}
+	return l, nil
+}
+
+func (a *Agent) serveControl(l net.Listener) {
+	defer a.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-a.stop:
+				return
+			default:
+			}
+			listenerLog.Log(pkg.Lerror, "accept failed", "err", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		a.handleControl(conn)
+	}
+}
+
+// handleControl runs the one command line sent on conn.
+func (a *Agent) handleControl(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+	line = strings.TrimSpace(line)
+	name, arg := line, ""
+	if i := strings.Index(line, "="); i >= 0 {
+		name, arg = line[:i], line[i+1:]
+	}
+	listenerLog.Log(pkg.Linfo, "control command", "command", line)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(conn, "error: unknown command %q, try help\n", name)
+		return
+	}
+	reply, err := cmd(a, arg)
+	if err != nil {
+		fmt.Fprintf(conn, "error: %v\n", err)
+		return
+	}
+	fmt.Fprintln(conn, reply)
+}
+
+// Control sends command to the agent listening on the control socket at
+// path and returns its reply.
+func Control(path, command string) (string, error) {
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return "", fmt.Errorf("cannot connect to the agent: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+	if _, err := fmt.Fprintln(conn, command); err != nil {
+		return "", err
+	}
+	reply, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSpace(string(reply))
+	if strings.HasPrefix(s, "error: ") {
+		return "", fmt.Errorf("%s", strings.TrimPrefix(s, "error: "))
+	}
+	return s, nil
+}
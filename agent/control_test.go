@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"../config"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestControl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "control")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "agent.sock")
+
+	// a socket file left by an agent that did not exit cleanly is replaced
+	stale, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	conf := testConfig("agent.ping")
+	conf.Agent.ControlSocket = path
+	var reloads int32
+	a, _, done := newTestAgent(t, conf, func() (*config.Config, error) {
+		atomic.AddInt32(&reloads, 1)
+		return conf, nil
+	})
+	defer done()
+	errc := make(chan error, 1)
+	go func() { errc <- a.Run() }()
+	defer func() {
+		a.Stop()
+		if err := <-errc; err != nil {
+			t.Error(err)
+		}
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := Control(path, "help"); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, err := listenControl(path); err == nil || !strings.Contains(err.Error(), "in use by another agent") {
+		t.Errorf("second listener: got %v", err)
+	}
+
+	for _, c := range []struct{ command, want string }{
+		{"help", "commands: config_reload, help, log_level_decrease, log_level_increase, reload"},
+		{"config_reload", "config reloaded"},
+		{"reload", "config reloaded"},
+		{"log_level_decrease=sender", "log level of sender set to warn"},
+		{"log_level_increase=sender", "log level of sender set to info"},
+	} {
+		if got, err := Control(path, c.command); got != c.want || err != nil {
+			t.Errorf("%s: got %q, %v, want %q", c.command, got, err, c.want)
+		}
+	}
+	if n := atomic.LoadInt32(&reloads); n != 2 {
+		t.Errorf("%d reloads, want 2", n)
+	}
+	for command, want := range map[string]string{
+		"restart":                   `unknown command "restart", try help`,
+		"log_level_increase=mailer": `unknown component "mailer"`,
+	} {
+		if _, err := Control(path, command); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: got %v, want %q", command, err, want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*******************************************************************************
+* FileName:  log.go
+* Author: Victor
+* Date: 2019/08/29 14:20
+* Description: logger setup from the agent configuration
+* Project: zabbix_agent
+*******************************************************************************/
+package agent
+
+import (
+	"../collector"
+	"../config"
+	"../pkg"
+	"io"
+	"os"
+	"time"
+)
+
+// rotateIntervals maps agent.logrotate onto the rotation interval.
+var rotateIntervals = map[string]time.Duration{
+	"":       0,
+	"hourly": time.Hour,
+	"daily":  24 * time.Hour,
+	"weekly": 7 * 24 * time.Hour,
+}
+
+// logTag is the identifier of the agent in syslog and the journal.
+const logTag = "zabbix_agent"
+
+// openLog points the standard logger at the output selected by
+// agent.logtype: agent.logfile, the syslog daemon or the journal, or
+// standard error for console and for a file type without a file name.
+// Syslog and the journal keep their own time stamp and priority, so the
+// records only carry the caller.
+func (a *Agent) openLog(conf *config.Config) {
+	switch conf.Agent.LogType {
+	case "syslog":
+		a.logw = pkg.NewSyslogWriter(conf.Agent.LogSocket, logTag, pkg.LogDaemon)
+		pkg.SetFlags(pkg.Lshortfile)
+	case "journald":
+		a.logw = pkg.NewJournalWriter(conf.Agent.LogSocket, logTag)
+		pkg.SetFlags(pkg.Lshortfile)
+	case "file":
+		if conf.Agent.LogFile == "" {
+			break
+		}
+		a.logw = &pkg.RotateWriter{
+			Filename:   conf.Agent.LogFile,
+			MaxSize:    int64(conf.Agent.LogFileSize) << 20,
+			Interval:   rotateIntervals[conf.Agent.LogRotate],
+			MaxAge:     time.Duration(conf.Agent.LogMaxAge) * 24 * time.Hour,
+			MaxBackups: conf.Agent.LogMaxBackups,
+			Compress:   conf.Agent.LogCompress,
+		}
+	}
+	var out io.Writer = os.Stderr
+	if a.logw != nil {
+		out = a.logw
+	}
+	if conf.Agent.LogQueue > 0 {
+		policy, err := pkg.ParseDropPolicy(conf.Agent.LogDrop)
+		if err != nil {
+			pkg.Errorf("agent.logdrop: %v", err)
+		}
+		a.logq = pkg.NewAsyncWriter(out, conf.Agent.LogQueue, policy)
+		out = a.logq
+	}
+	pkg.SetOutput(out)
+}
+
+// ReopenLog reopens the log file, after an external logrotate moved it.
+// Other outputs have nothing to reopen.
+func (a *Agent) ReopenLog() error {
+	w, ok := a.logw.(*pkg.RotateWriter)
+	if !ok {
+		return nil
+	}
+	if err := w.Reopen(); err != nil {
+		return err
+	}
+	pkg.Infof("log file %s reopened", w.Filename)
+	return nil
+}
+
+// closeLog switches the standard logger back to standard error, writes
+// what is still queued and closes the previous output.
+func (a *Agent) closeLog() {
+	pkg.FlushRepeats()
+	pkg.SetOutput(os.Stderr)
+	if a.logq != nil {
+		a.logq.Close()
+		if n := a.logq.Dropped(); n > 0 {
+			pkg.Warnf("%d log records dropped because the log queue was full", n)
+		}
+	}
+	if a.logw != nil {
+		a.logw.Close()
+	}
+}
+
+// Component loggers. Their levels come from log.levels; the collector of
+// an item logs to collector:<key name>, see collectorLog.
+var (
+	senderLog    = pkg.Named("sender")
+	schedulerLog = pkg.Named("scheduler")
+	listenerLog  = pkg.Named("listener")
+)
+
+// collectorLog returns the logger of the collector behind key.
+func collectorLog(key string) *pkg.Logger {
+	name, _, err := collector.ParseKey(key)
+	if err != nil {
+		name = key
+	}
+	return pkg.Named("collector:" + name)
+}
+
+// setLogOptions applies agent.loglevel, agent.logformat, agent.logrepeat,
+// agent.lograte and log.levels to the standard logger. The config has been
+// validated, so an unknown value cannot happen.
+func setLogOptions(conf *config.Config) {
+	level, err := pkg.ParseLevel(conf.Agent.LogLevel)
+	if err != nil {
+		pkg.Errorf("agent.loglevel: %v", err)
+		return
+	}
+	levels := make(map[string]pkg.Level, len(conf.Log.Levels))
+	for name, s := range conf.Log.Levels {
+		if levels[name], err = pkg.ParseLevel(s); err != nil {
+			pkg.Errorf("log.levels: %s: %v", name, err)
+			return
+		}
+	}
+	format, err := pkg.ParseFormat(conf.Agent.LogFormat)
+	if err != nil {
+		pkg.Errorf("agent.logformat: %v", err)
+		return
+	}
+	pkg.SetLevel(level)
+	pkg.SetLevels(levels)
+	pkg.SetFormat(format)
+	pkg.SetRepeatWindow(time.Duration(conf.Agent.LogRepeat) * time.Second)
+	pkg.SetRateLimit(conf.Agent.LogRate)
+}
@@ -0,0 +1,138 @@
+/*******************************************************************************
+* FileName:  collector.go
+* Author: Victor
+* Date: 2019/08/27 11:05
+* Description: registry of item collectors
+* Project: zabbix_agent
+*******************************************************************************/
+
+// Package collector turns item keys into values. Built-in items register a
+// Func under their key name; user parameters run a shell command.
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Func collects the value of one item from the parameters of its key.
+type Func func(params []string) (interface{}, error)
+
+// TimedFunc is a Func that is also given the timeout of its item, for
+// collectors that wait on something they have to give up on in time.
+type TimedFunc func(params []string, timeout time.Duration) (interface{}, error)
+
+// ErrUnsupported is returned for keys no collector knows about.
+var ErrUnsupported = errors.New("unsupported item key")
+
+var (
+	mu         sync.RWMutex
+	funcs      = make(map[string]TimedFunc)
+	userParams UserParameters
+	recovering bool
+)
+
+// PanicError is returned by Collect for a collector that panicked while
+// panics are recovered, see SetRecover.
+type PanicError struct {
+	Key   string
+	Value interface{} // the value passed to panic
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s: collector panicked: %v", e.Key, e.Value)
+}
+
+// SetRecover decides what a panicking collector does: with on it fails
+// its item with a *PanicError, otherwise it crashes the agent.
+func SetRecover(on bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	recovering = on
+}
+
+// Register makes fn collect the items named name. It panics if name is
+// already registered, as two collectors for one key is a programming error.
+func Register(name string, fn Func) {
+	RegisterTimed(name, func(params []string, _ time.Duration) (interface{}, error) {
+		return fn(params)
+	})
+}
+
+// RegisterTimed is Register for a collector given the timeout of its item.
+func RegisterTimed(name string, fn TimedFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := funcs[name]; ok {
+		panic("collector: " + name + " registered twice")
+	}
+	funcs[name] = fn
+}
+
+// Registered reports whether name is a built-in item.
+func Registered(name string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	_, ok := funcs[name]
+	return ok
+}
+
+// Names returns the names of the built-in items, sorted.
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Collect returns the current value of key. A collector that does not
+// finish within timeout is abandoned and an error returned.
+func Collect(key string, timeout time.Duration) (interface{}, error) {
+	name, params, err := ParseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	mu.RLock()
+	fn, ok := funcs[name]
+	up := userParams[name]
+	protect := recovering
+	mu.RUnlock()
+	if !ok && up == nil {
+		return nil, fmt.Errorf("%s: %v", key, ErrUnsupported)
+	}
+	if up != nil {
+		fn = up.run
+	}
+
+	type result struct {
+		v   interface{}
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		if protect {
+			defer func() {
+				if r := recover(); r != nil {
+					done <- result{err: &PanicError{Key: key, Value: r, Stack: debug.Stack()}}
+				}
+			}()
+		}
+		v, err := fn(params, timeout)
+		done <- result{v, err}
+	}()
+	select {
+	case r := <-done:
+		return r.v, r.err
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("%s: timed out after %v", key, timeout)
+	}
+}
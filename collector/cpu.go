@@ -0,0 +1,237 @@
+/*******************************************************************************
+* FileName:  cpu.go
+* Author: Victor
+* Date: 2019/09/03 11:30
+* Description: CPU items: system.cpu.util, load, num, switches and intr
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("system.cpu.util", cpuUtil)
+	Register("system.cpu.load", cpuLoad)
+	Register("system.cpu.num", cpuNum)
+	Register("system.cpu.switches", func(params []string) (interface{}, error) {
+		if err := maxParams(params, 0); err != nil {
+			return nil, err
+		}
+		return statCounter("ctxt")
+	})
+	Register("system.cpu.intr", func(params []string) (interface{}, error) {
+		if err := maxParams(params, 0); err != nil {
+			return nil, err
+		}
+		return statCounter("intr")
+	})
+}
+
+// cpuStates are the columns of a cpu line of /proc/stat, in order, by
+// their system.cpu.util type.
+var cpuStates = []string{"user", "nice", "system", "idle", "iowait", "interrupt", "softirq", "steal",
+	"guest", "guest_nice"}
+
+// cpuTimes are the jiffies spent by one CPU in each of cpuStates.
+type cpuTimes [10]uint64
+
+// total returns the jiffies of all the states. Guest time is already
+// counted as user and nice time.
+func (t cpuTimes) total() uint64 {
+	var sum uint64
+	for _, v := range t[:8] {
+		sum += v
+	}
+	return sum
+}
+
+// cpuStat holds the cpu lines of /proc/stat, keyed by CPU number with -1
+// for the "cpu" line summing them all.
+type cpuStat map[int]cpuTimes
+
+func readCPUStat() (interface{}, error) {
+	lines, err := readLines("proc", "stat")
+	if err != nil {
+		return nil, err
+	}
+	stat := make(cpuStat)
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		n := -1
+		if fields[0] != "cpu" {
+			if n, err = strconv.Atoi(fields[0][3:]); err != nil {
+				continue
+			}
+		}
+		var t cpuTimes
+		for i := 1; i < len(fields) && i <= len(t); i++ {
+			if t[i-1], err = parseUint(fields[i]); err != nil {
+				return nil, fmt.Errorf("/proc/stat: %s: %v", fields[0], err)
+			}
+		}
+		stat[n] = t
+	}
+	if _, ok := stat[-1]; !ok {
+		return nil, fmt.Errorf("/proc/stat: no cpu line")
+	}
+	return stat, nil
+}
+
+var cpuSampler = newSampler("cpu sampler", readCPUStat)
+
+// cpuUtil is system.cpu.util[<cpu>,<type>,<mode>]: the percentage of time
+// the CPU (all by default) spent in type (user by default) over the last
+// 1, 5 or 15 minutes (avg1 by default).
+func cpuUtil(params []string) (interface{}, error) {
+	if err := maxParams(params, 3); err != nil {
+		return nil, err
+	}
+	cpu := -1
+	if p := param(params, 0); p != "" && p != "all" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid cpu %q", p)
+		}
+		cpu = n
+	}
+	state, err := oneOf(params, 1, "type", cpuStates...)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := oneOf(params, 2, "mode", "avg1", "avg5", "avg15")
+	if err != nil {
+		return nil, err
+	}
+	first, last, err := cpuSampler.window(averages[mode])
+	if err != nil {
+		return nil, err
+	}
+	from, ok1 := first.v.(cpuStat)[cpu]
+	to, ok2 := last.v.(cpuStat)[cpu]
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("cpu %d is offline or does not exist", cpu)
+	}
+	i := 0
+	for cpuStates[i] != state {
+		i++
+	}
+	total := to.total() - from.total()
+	if total == 0 || to[i] < from[i] {
+		return 0.0, nil
+	}
+	return 100 * float64(to[i]-from[i]) / float64(total), nil
+}
+
+// cpuLoad is system.cpu.load[<cpu>,<mode>]: the load average over 1, 5 or
+// 15 minutes, for the system (all) or divided by the online CPUs (percpu).
+func cpuLoad(params []string) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	cpu, err := oneOf(params, 0, "cpu", "all", "percpu")
+	if err != nil {
+		return nil, err
+	}
+	mode, err := oneOf(params, 1, "mode", "avg1", "avg5", "avg15")
+	if err != nil {
+		return nil, err
+	}
+	s, err := readString("proc", "loadavg")
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(s)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("/proc/loadavg: unexpected content %q", s)
+	}
+	load, err := strconv.ParseFloat(fields[map[string]int{"avg1": 0, "avg5": 1, "avg15": 2}[mode]], 64)
+	if err != nil {
+		return nil, fmt.Errorf("/proc/loadavg: %v", err)
+	}
+	if cpu == "percpu" {
+		n, err := countCPUs("online")
+		if err != nil {
+			return nil, err
+		}
+		load /= float64(n)
+	}
+	return load, nil
+}
+
+// cpuNum is system.cpu.num[online|max].
+func cpuNum(params []string) (interface{}, error) {
+	if err := maxParams(params, 1); err != nil {
+		return nil, err
+	}
+	which, err := oneOf(params, 0, "type", "online", "max")
+	if err != nil {
+		return nil, err
+	}
+	return countCPUs(which)
+}
+
+// countCPUs counts the online or the possible ("max") CPUs from the lists
+// in /sys/devices/system/cpu. Without sysfs the online CPUs are those
+// listed in /proc/stat.
+func countCPUs(which string) (int, error) {
+	file := "online"
+	if which == "max" {
+		file = "possible"
+	}
+	s, err := readString("sys", "devices", "system", "cpu", file)
+	if err == nil {
+		return countRange(s)
+	}
+	if which == "max" {
+		return 0, err
+	}
+	v, err := readCPUStat()
+	if err != nil {
+		return 0, err
+	}
+	return len(v.(cpuStat)) - 1, nil
+}
+
+// countRange counts the numbers of a kernel CPU list such as "0-3,8,10-11".
+func countRange(s string) (int, error) {
+	n := 0
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		lo, hi := part, part
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+		a, err1 := strconv.Atoi(lo)
+		b, err2 := strconv.Atoi(hi)
+		if err1 != nil || err2 != nil || b < a {
+			return 0, fmt.Errorf("invalid CPU list %q", s)
+		}
+		n += b - a + 1
+	}
+	return n, nil
+}
+
+// statCounter returns the first number of the /proc/stat line starting
+// with name, e.g. ctxt or intr.
+func statCounter(name string) (interface{}, error) {
+	lines, err := readLines("proc", "stat")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == name {
+			return parseUint(fields[1])
+		}
+	}
+	return nil, fmt.Errorf("/proc/stat: no %s line", name)
+}
@@ -0,0 +1,180 @@
+package collector
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestdata points the built-in collectors at the fixture tree of
+// testdata until the returned function is called.
+func useTestdata() func() {
+	SetHostRoot("testdata")
+	return func() { SetHostRoot("/") }
+}
+
+// collect returns the value of key, failing the test on an error.
+func collect(t *testing.T, key string) interface{} {
+	t.Helper()
+	v, err := Collect(key, time.Second)
+	if err != nil {
+		t.Fatalf("%s: %v", key, err)
+	}
+	return v
+}
+
+// collectErr returns the error of key, failing the test if there is none.
+func collectErr(t *testing.T, key string) error {
+	t.Helper()
+	v, err := Collect(key, time.Second)
+	if err == nil {
+		t.Fatalf("%s: got %v, want an error", key, v)
+	}
+	return err
+}
+
+func checkFloat(t *testing.T, key string, got interface{}, want float64) {
+	t.Helper()
+	f, ok := got.(float64)
+	if !ok || math.Abs(f-want) > 1e-9 {
+		t.Errorf("%s = %v (%T), want %v", key, got, got, want)
+	}
+}
+
+// fedSampler returns a sampler holding samples, oldest first, that never
+// collects on its own.
+func fedSampler(samples ...sample) *sampler {
+	s := newSampler("fed sampler", func() (interface{}, error) { panic("fed sampler collecting") })
+	s.once.Do(func() {})
+	close(s.ready)
+	for _, v := range samples {
+		s.samples[s.next] = v
+		s.next = (s.next + 1) % len(s.samples)
+		s.n++
+	}
+	return s
+}
+
+// cpuTimesOf returns the times of a CPU spending only user, system and
+// idle time.
+func cpuTimesOf(user, system, idle uint64) cpuTimes {
+	return cpuTimes{user, 0, system, idle}
+}
+
+func TestReadCPUStat(t *testing.T) {
+	defer useTestdata()()
+	v, err := readCPUStat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := v.(cpuStat)
+	if len(stat) != 3 {
+		t.Fatalf("got %d cpu lines, want 3", len(stat))
+	}
+	want := cpuTimes{1393, 280, 290, 1808, 16, 0, 6, 0, 0, 0}
+	if stat[0] != want {
+		t.Errorf("cpu0 = %v, want %v", stat[0], want)
+	}
+	if total := stat[-1].total(); total != 4705+356+584+3699+23+12 {
+		t.Errorf("total of all = %d", total)
+	}
+}
+
+func TestCPUUtil(t *testing.T) {
+	now := time.Now()
+	at := func(ago time.Duration, all, cpu0 cpuTimes) sample {
+		return sample{now.Add(-ago), cpuStat{-1: all, 0: cpu0}}
+	}
+	// samples at the start of the avg15, avg5 and avg1 windows, and now
+	saved := cpuSampler
+	cpuSampler = fedSampler(
+		at(15*time.Minute, cpuTimesOf(0, 0, 0), cpuTimesOf(0, 0, 0)),
+		at(5*time.Minute, cpuTimesOf(100, 0, 500), cpuTimesOf(10, 10, 100)),
+		at(time.Minute, cpuTimesOf(200, 0, 1000), cpuTimesOf(50, 10, 140)),
+		at(0, cpuTimesOf(300, 100, 1200), cpuTimesOf(100, 60, 240)),
+	)
+	defer func() { cpuSampler = saved }()
+
+	for key, want := range map[string]float64{
+		"system.cpu.util":                   25, // all, user, avg1
+		"system.cpu.util[,system]":          25,
+		"system.cpu.util[all,idle,avg1]":    50,
+		"system.cpu.util[,user,avg5]":       20,
+		"system.cpu.util[,idle,avg5]":       70,
+		"system.cpu.util[,user,avg15]":      18.75,
+		"system.cpu.util[,system,avg15]":    6.25,
+		"system.cpu.util[0]":                25,
+		"system.cpu.util[0,system]":         25,
+		"system.cpu.util[0,idle,avg5]":      50,
+		"system.cpu.util[0,user,avg15]":     25,
+		"system.cpu.util[0,iowait,avg15]":   0,
+		"system.cpu.util[,steal]":           0,
+		"system.cpu.util[all,softirq,avg1]": 0,
+	} {
+		checkFloat(t, key, collect(t, key), want)
+	}
+	for _, key := range []string{
+		"system.cpu.util[1]", // not sampled
+		"system.cpu.util[x]",
+		"system.cpu.util[,busy]",
+		"system.cpu.util[,user,avg2]",
+		"system.cpu.util[,,,]",
+	} {
+		collectErr(t, key)
+	}
+}
+
+func TestCPUUtilStarting(t *testing.T) {
+	saved := cpuSampler
+	cpuSampler = fedSampler(sample{time.Now(), cpuStat{-1: cpuTimesOf(1, 1, 1)}})
+	defer func() { cpuSampler = saved }()
+	if err := collectErr(t, "system.cpu.util"); !strings.Contains(err.Error(), "initial data") {
+		t.Errorf("with one sample: %v", err)
+	}
+}
+
+func TestCPULoad(t *testing.T) {
+	defer useTestdata()()
+	for key, want := range map[string]float64{
+		"system.cpu.load":               0.5,
+		"system.cpu.load[all,avg5]":     1,
+		"system.cpu.load[,avg15]":       2,
+		"system.cpu.load[percpu]":       0.25, // 2 CPUs online
+		"system.cpu.load[percpu,avg15]": 1,
+	} {
+		checkFloat(t, key, collect(t, key), want)
+	}
+	collectErr(t, "system.cpu.load[some]")
+	collectErr(t, "system.cpu.load[,avg2]")
+}
+
+func TestCPUNumAndCounters(t *testing.T) {
+	defer useTestdata()()
+	for key, want := range map[string]interface{}{
+		"system.cpu.num":         2,
+		"system.cpu.num[online]": 2,
+		"system.cpu.num[max]":    4,
+		"system.cpu.switches":    uint64(123456),
+		"system.cpu.intr":        uint64(98765),
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %v (%T), want %v", key, got, got, want)
+		}
+	}
+	collectErr(t, "system.cpu.num[min]")
+	collectErr(t, "system.cpu.switches[1]")
+}
+
+func TestCountRange(t *testing.T) {
+	for s, want := range map[string]int{"0": 1, "0-3": 4, "0-3,8,10-11": 7, "": 0, "0-1\n": 2} {
+		if got, err := countRange(strings.TrimSpace(s)); err != nil || got != want {
+			t.Errorf("countRange(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"3-1", "a", "1-"} {
+		if _, err := countRange(s); err == nil {
+			t.Errorf("countRange(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+/*******************************************************************************
+* FileName:  disk.go
+* Author: Victor
+* Date: 2019/09/05 09:50
+* Description: block device items: vfs.dev.read, vfs.dev.write and
+*              vfs.dev.discovery
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"../pkg"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	Register("vfs.dev.read", func(params []string) (interface{}, error) {
+		return devIO(params, true)
+	})
+	Register("vfs.dev.write", func(params []string) (interface{}, error) {
+		return devIO(params, false)
+	})
+	Register("vfs.dev.discovery", devDiscovery)
+}
+
+// sectorSize is the unit of the sector counters of /proc/diskstats,
+// whatever the sector size of the device.
+const sectorSize = 512
+
+// devTypes are the types of vfs.dev.read and vfs.dev.write; the first is
+// the default. The first three are rates, the others counters.
+var devTypes = []string{"sps", "ops", "bps", "sectors", "operations", "bytes"}
+
+// devType returns "partition" or "disk" for a device of /proc/diskstats.
+func devType(name string) string {
+	if lines, err := readLines("sys", "class", "block", name, "uevent"); err == nil {
+		for _, line := range lines {
+			if strings.HasPrefix(line, "DEVTYPE=") {
+				return strings.TrimPrefix(line, "DEVTYPE=")
+			}
+		}
+	}
+	if _, err := os.Stat(hostPath("sys", "class", "block", name, "partition")); err == nil {
+		return "partition"
+	}
+	return "disk"
+}
+
+// readDiskSample returns the devices of /proc/diskstats by name, for
+// diskSampler.
+func readDiskSample() (interface{}, error) {
+	stats, err := readDiskstats()
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]diskStat, len(stats))
+	for _, s := range stats {
+		byName[s.name] = s
+	}
+	return byName, nil
+}
+
+var diskSampler = newSampler("disk sampler", readDiskSample)
+
+// devCounters returns the operations and sectors read or written by dev,
+// or by all the disks, leaving out partitions so nothing is counted twice.
+func devCounters(stats map[string]diskStat, dev string, read bool) (ops, sectors uint64, err error) {
+	add := func(s diskStat) {
+		if read {
+			ops += s.reads
+			sectors += s.readSectors
+		} else {
+			ops += s.writes
+			sectors += s.writeSectors
+		}
+	}
+	if dev != "" {
+		s, ok := stats[dev]
+		if !ok {
+			return 0, 0, fmt.Errorf("device %s not found in /proc/diskstats", dev)
+		}
+		add(s)
+		return ops, sectors, nil
+	}
+	for name, s := range stats {
+		if devType(name) == "disk" {
+			add(s)
+		}
+	}
+	return ops, sectors, nil
+}
+
+// devIO is vfs.dev.read and vfs.dev.write[<device>,<type>,<mode>]: the
+// sectors, operations or bytes read or written per second (sps by default)
+// averaged over 1, 5 or 15 minutes (avg1 by default), or their counters,
+// for one device or for all the disks.
+func devIO(params []string, read bool) (interface{}, error) {
+	if err := maxParams(params, 3); err != nil {
+		return nil, err
+	}
+	dev := devName(param(params, 0))
+	if dev == "all" {
+		dev = ""
+	}
+	typ, err := oneOf(params, 1, "type", devTypes...)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := oneOf(params, 2, "mode", "avg1", "avg5", "avg15")
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case "sectors", "operations", "bytes":
+		if param(params, 2) != "" {
+			return nil, fmt.Errorf("mode is only supported by the sps, ops and bps types")
+		}
+		v, err := readDiskSample()
+		if err != nil {
+			return nil, err
+		}
+		ops, sectors, err := devCounters(v.(map[string]diskStat), dev, read)
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case "sectors":
+			return sectors, nil
+		case "operations":
+			return ops, nil
+		}
+		return sectors * sectorSize, nil
+	}
+
+	first, last, err := diskSampler.window(averages[mode])
+	if err != nil {
+		return nil, err
+	}
+	ops1, sectors1, err := devCounters(first.v.(map[string]diskStat), dev, read)
+	if err != nil {
+		return nil, err
+	}
+	ops2, sectors2, err := devCounters(last.v.(map[string]diskStat), dev, read)
+	if err != nil {
+		return nil, err
+	}
+	seconds := last.t.Sub(first.t).Seconds()
+	rate := func(from, to uint64) float64 {
+		if to < from { // the counter wrapped or the device was replaced
+			return 0
+		}
+		return float64(to-from) / seconds
+	}
+	switch typ {
+	case "ops":
+		return rate(ops1, ops2), nil
+	case "bps":
+		return rate(sectors1, sectors2) * sectorSize, nil
+	}
+	return rate(sectors1, sectors2), nil
+}
+
+// devDiscovery is vfs.dev.discovery: {#DEVNAME} and {#DEVTYPE} of every
+// block device of /proc/diskstats.
+func devDiscovery(params []string) (interface{}, error) {
+	if err := maxParams(params, 0); err != nil {
+		return nil, err
+	}
+	stats, err := readDiskstats()
+	if err != nil {
+		return nil, err
+	}
+	d := pkg.DiscoveryData{Data: make([]interface{}, 0, len(stats))}
+	for _, s := range stats {
+		d.Data = append(d.Data, map[string]string{
+			"{#DEVNAME}": s.name,
+			"{#DEVTYPE}": devType(s.name),
+		})
+	}
+	return d, nil
+}
@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"../pkg"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDevType(t *testing.T) {
+	defer useTestdata()()
+	for name, want := range map[string]string{
+		"sda":     "disk",      // DEVTYPE of uevent
+		"sda1":    "partition", // DEVTYPE of uevent
+		"sda2":    "partition", // no uevent, a partition file
+		"nvme0n1": "disk",      // nothing in sysfs
+	} {
+		if got := devType(name); got != want {
+			t.Errorf("devType(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestDevCounters(t *testing.T) {
+	defer useTestdata()()
+	for key, want := range map[string]uint64{
+		// the disks sda and nvme0n1, not their partitions
+		"vfs.dev.read[,operations]":           1400,
+		"vfs.dev.read[all,sectors]":           11200,
+		"vfs.dev.write[,operations]":          2600,
+		"vfs.dev.write[,bytes]":               20800 * 512,
+		"vfs.dev.read[sda1,sectors]":          7920,
+		"vfs.dev.read[/dev/sda,bytes]":        8000 * 512,
+		"vfs.dev.write[/dev/sda2,operations]": 20,
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %v (%T), want %d", key, got, got, want)
+		}
+	}
+	for _, key := range []string{
+		"vfs.dev.read[sdb,sectors]",
+		"vfs.dev.read[,blocks]",
+		"vfs.dev.read[,sectors,avg1]", // counters have no mode
+		"vfs.dev.write[,,avg2]",
+	} {
+		collectErr(t, key)
+	}
+}
+
+func TestDevRates(t *testing.T) {
+	defer useTestdata()()
+	now := time.Now()
+	at := func(ago time.Duration, reads, sectors, nvmeReads uint64) sample {
+		return sample{now.Add(-ago), map[string]diskStat{
+			"sda": {name: "sda", reads: reads, readSectors: sectors,
+				writes: reads / 10, writeSectors: sectors / 10},
+			"sda1":    {name: "sda1", reads: reads, readSectors: sectors}, // counted in sda
+			"nvme0n1": {name: "nvme0n1", reads: nvmeReads},
+		}}
+	}
+	saved := diskSampler
+	diskSampler = fedSampler(
+		at(5*time.Minute, 0, 0, 0),
+		at(time.Minute, 300, 3000, 1000),
+		at(0, 600, 6000, 10), // nvme0n1 was replaced
+	)
+	defer func() { diskSampler = saved }()
+
+	for key, want := range map[string]float64{
+		"vfs.dev.read[sda]":              50, // sps, avg1
+		"vfs.dev.read[/dev/sda,ops]":     5,
+		"vfs.dev.read[sda,bps]":          50 * 512,
+		"vfs.dev.read[sda,sps,avg5]":     20,
+		"vfs.dev.read[sda,ops,avg5]":     2,
+		"vfs.dev.write[sda,sps]":         5,
+		"vfs.dev.write[sda,ops,avg5]":    0.2,
+		"vfs.dev.read[sda1,sps]":         50,
+		"vfs.dev.read[nvme0n1,ops]":      0,          // went down
+		"vfs.dev.read[nvme0n1,ops,avg5]": 10.0 / 300, // from the first sample
+		"vfs.dev.read[,sps]":             50,         // sda alone: sda1 is a partition
+	} {
+		checkFloat(t, key, collect(t, key), want)
+	}
+	collectErr(t, "vfs.dev.read[sdb]")
+}
+
+func TestDevDiscovery(t *testing.T) {
+	defer useTestdata()()
+	got := collect(t, "vfs.dev.discovery").(pkg.DiscoveryData).Data
+	dev := func(name, typ string) map[string]string {
+		return map[string]string{"{#DEVNAME}": name, "{#DEVTYPE}": typ}
+	}
+	want := []interface{}{dev("sda", "disk"), dev("sda1", "partition"), dev("sda2", "partition"),
+		dev("nvme0n1", "disk")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("vfs.dev.discovery = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,56 @@
+/*******************************************************************************
+* FileName:  diskstats.go
+* Author: Victor
+* Date: 2019/09/04 09:40
+* Description: parsing of /proc/diskstats
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diskStat holds the counters of one block device in /proc/diskstats.
+type diskStat struct {
+	major, minor string
+	name         string
+
+	reads, readsMerged, readSectors, readMillis     uint64
+	writes, writesMerged, writeSectors, writeMillis uint64
+	inProgress, ioMillis, weightedMillis            uint64
+}
+
+// readDiskstats returns the devices of /proc/diskstats in file order.
+func readDiskstats() ([]diskStat, error) {
+	lines, err := readLines("proc", "diskstats")
+	if err != nil {
+		return nil, err
+	}
+	var stats []diskStat
+	for _, line := range lines {
+		f := strings.Fields(line)
+		if len(f) < 14 {
+			continue
+		}
+		d := diskStat{major: f[0], minor: f[1], name: f[2]}
+		for i, p := range []*uint64{
+			&d.reads, &d.readsMerged, &d.readSectors, &d.readMillis,
+			&d.writes, &d.writesMerged, &d.writeSectors, &d.writeMillis,
+			&d.inProgress, &d.ioMillis, &d.weightedMillis,
+		} {
+			if *p, err = parseUint(f[3+i]); err != nil {
+				return nil, fmt.Errorf("/proc/diskstats: %s: %v", d.name, err)
+			}
+		}
+		stats = append(stats, d)
+	}
+	return stats, nil
+}
+
+// devName strips /dev/ from a device path, as /proc/diskstats names
+// devices without it.
+func devName(dev string) string {
+	return strings.TrimPrefix(dev, "/dev/")
+}
@@ -0,0 +1,218 @@
+/*******************************************************************************
+* FileName:  fs.go
+* Author: Victor
+* Date: 2019/09/04 15:20
+* Description: file system items: vfs.fs.size, vfs.fs.inode, vfs.fs.get and
+*              vfs.fs.discovery
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"../pkg"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func init() {
+	Register("vfs.fs.size", fsSize)
+	Register("vfs.fs.inode", fsInode)
+	Register("vfs.fs.get", fsGet)
+	Register("vfs.fs.discovery", fsDiscovery)
+}
+
+// mount is one line of /proc/mounts.
+type mount struct {
+	device, dir, fstype string
+}
+
+func readMounts() ([]mount, error) {
+	lines, err := readLines("proc", "mounts")
+	if err != nil {
+		return nil, err
+	}
+	var mounts []mount
+	for _, line := range lines {
+		f := strings.Fields(line)
+		if len(f) < 3 {
+			continue
+		}
+		mounts = append(mounts, mount{unescapeMount(f[0]), unescapeMount(f[1]), f[2]})
+	}
+	return mounts, nil
+}
+
+// unescapeMount decodes the octal escapes of /proc/mounts, e.g. \040 for
+// a space.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// fsDriveTypes classifies file system types for {#FSDRIVETYPE}; the rest
+// are fixed.
+var fsDriveTypes = map[string]string{
+	"nfs": "network", "nfs4": "network", "cifs": "network", "smbfs": "network",
+	"ceph": "network", "glusterfs": "network", "fuse.sshfs": "network", "9p": "network",
+	"iso9660": "cdrom", "udf": "cdrom",
+	"tmpfs": "ram", "ramfs": "ram", "devtmpfs": "ram",
+}
+
+func driveType(fstype string) string {
+	if t, ok := fsDriveTypes[fstype]; ok {
+		return t
+	}
+	return "fixed"
+}
+
+// fsUsage is the space or inode usage of a file system, as in vfs.fs.get.
+type fsUsage struct {
+	Total uint64  `json:"total"`
+	Free  uint64  `json:"free"`
+	Used  uint64  `json:"used"`
+	PFree float64 `json:"pfree"`
+	PUsed float64 `json:"pused"`
+}
+
+// statFS returns the space and inode usage of the file system mounted at
+// dir. Free space is what unprivileged users may still use, so the
+// percentages leave out the blocks reserved for root.
+func statFS(dir string) (bytes, inodes fsUsage, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(hostPath(dir), &st); err != nil {
+		return bytes, inodes, fmt.Errorf("%s: %v", dir, err)
+	}
+	bsize := uint64(st.Bsize)
+	used := st.Blocks - st.Bfree
+	bytes = fsUsage{Total: st.Blocks * bsize, Free: st.Bavail * bsize, Used: used * bsize}
+	if avail := st.Bavail + used; avail > 0 {
+		bytes.PFree = 100 * float64(st.Bavail) / float64(avail)
+		bytes.PUsed = 100 - bytes.PFree
+	}
+	inodes = fsUsage{Total: st.Files, Free: st.Ffree, Used: st.Files - st.Ffree}
+	if st.Files > 0 {
+		inodes.PFree = 100 * float64(st.Ffree) / float64(st.Files)
+		inodes.PUsed = 100 - inodes.PFree
+	}
+	return bytes, inodes, nil
+}
+
+// usageValue returns mode (total by default, free, used, pfree or pused)
+// of u.
+func usageValue(params []string, u fsUsage) (interface{}, error) {
+	mode, err := oneOf(params, 1, "mode", "total", "free", "used", "pfree", "pused")
+	if err != nil {
+		return nil, err
+	}
+	if u.Total == 0 && (mode == "pfree" || mode == "pused") {
+		return nil, fmt.Errorf("cannot compute a percentage of nothing")
+	}
+	switch mode {
+	case "free":
+		return u.Free, nil
+	case "used":
+		return u.Used, nil
+	case "pfree":
+		return u.PFree, nil
+	case "pused":
+		return u.PUsed, nil
+	}
+	return u.Total, nil
+}
+
+// fsSize is vfs.fs.size[fs,<mode>], in bytes or percent.
+func fsSize(params []string) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	dir := param(params, 0)
+	if dir == "" {
+		return nil, fmt.Errorf("file system not given")
+	}
+	bytes, _, err := statFS(dir)
+	if err != nil {
+		return nil, err
+	}
+	return usageValue(params, bytes)
+}
+
+// fsInode is vfs.fs.inode[fs,<mode>], in inodes or percent.
+func fsInode(params []string) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	dir := param(params, 0)
+	if dir == "" {
+		return nil, fmt.Errorf("file system not given")
+	}
+	_, inodes, err := statFS(dir)
+	if err != nil {
+		return nil, err
+	}
+	return usageValue(params, inodes)
+}
+
+// fsInfo is one file system of vfs.fs.get.
+type fsInfo struct {
+	FSName string  `json:"fsname"`
+	FSType string  `json:"fstype"`
+	Bytes  fsUsage `json:"bytes"`
+	Inodes fsUsage `json:"inodes"`
+}
+
+// fsGet is vfs.fs.get: the usage of every mounted file system that can be
+// read.
+func fsGet(params []string) (interface{}, error) {
+	if err := maxParams(params, 0); err != nil {
+		return nil, err
+	}
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]fsInfo, 0, len(mounts))
+	for _, m := range mounts {
+		bytes, inodes, err := statFS(m.dir)
+		if err != nil {
+			continue
+		}
+		list = append(list, fsInfo{m.dir, m.fstype, bytes, inodes})
+	}
+	return list, nil
+}
+
+// fsDiscovery is vfs.fs.discovery: {#FSNAME}, {#FSTYPE} and {#FSDRIVETYPE}
+// of every mounted file system.
+func fsDiscovery(params []string) (interface{}, error) {
+	if err := maxParams(params, 0); err != nil {
+		return nil, err
+	}
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+	d := pkg.DiscoveryData{Data: make([]interface{}, 0, len(mounts))}
+	for _, m := range mounts {
+		d.Data = append(d.Data, map[string]string{
+			"{#FSNAME}":      m.dir,
+			"{#FSTYPE}":      m.fstype,
+			"{#FSDRIVETYPE}": driveType(m.fstype),
+		})
+	}
+	return d, nil
+}
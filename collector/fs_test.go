@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"../pkg"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestUnescapeMount(t *testing.T) {
+	for s, want := range map[string]string{
+		"/mnt/data":            "/mnt/data",
+		`/mnt/nfs\040share`:    "/mnt/nfs share",
+		`/mnt/tab\011and\134`:  "/mnt/tab\tand\\",
+		`/mnt/short\04`:        `/mnt/short\04`,
+		`/mnt/not\999an-octal`: `/mnt/not\999an-octal`,
+	} {
+		if got := unescapeMount(s); got != want {
+			t.Errorf("unescapeMount(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestFSDiscovery(t *testing.T) {
+	defer useTestdata()()
+	got := collect(t, "vfs.fs.discovery").(pkg.DiscoveryData).Data
+	fs := func(name, typ, drive string) map[string]string {
+		return map[string]string{"{#FSNAME}": name, "{#FSTYPE}": typ, "{#FSDRIVETYPE}": drive}
+	}
+	want := []interface{}{
+		fs("/", "ext4", "fixed"),
+		fs("/proc", "proc", "fixed"),
+		fs("/dev/shm", "tmpfs", "ram"),
+		fs("/mnt/nfs share", "nfs4", "network"),
+		fs("/media/cd", "iso9660", "cdrom"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("vfs.fs.discovery = %v, want %v", got, want)
+	}
+}
+
+// The file systems are those mounted below the host root: with the
+// fixtures, the one holding testdata.
+func TestFSSize(t *testing.T) {
+	defer useTestdata()()
+	var st syscall.Statfs_t
+	if err := syscall.Statfs("testdata", &st); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := collect(t, "vfs.fs.size[/]"), st.Blocks*uint64(st.Bsize); got != want {
+		t.Errorf("vfs.fs.size[/] = %v, want %d", got, want)
+	}
+	if got, want := collect(t, "vfs.fs.inode[/,total]"), st.Files; got != want {
+		t.Errorf("vfs.fs.inode[/,total] = %v, want %d", got, want)
+	}
+	pfree := collect(t, "vfs.fs.size[/,pfree]").(float64)
+	pused := collect(t, "vfs.fs.size[/,pused]").(float64)
+	if pfree < 0 || pfree > 100 || pfree+pused < 99.999 || pfree+pused > 100.001 {
+		t.Errorf("pfree %v and pused %v", pfree, pused)
+	}
+	for _, key := range []string{
+		"vfs.fs.size",
+		"vfs.fs.size[/dev/shm]", // not below the host root
+		"vfs.fs.size[/,avail]",
+		"vfs.fs.inode[]",
+		"vfs.fs.size[/,total,x]",
+	} {
+		collectErr(t, key)
+	}
+}
+
+func TestFSGet(t *testing.T) {
+	defer useTestdata()()
+	list := collect(t, "vfs.fs.get").([]fsInfo)
+	// only / and /proc exist below testdata
+	if len(list) != 2 || list[0].FSName != "/" || list[0].FSType != "ext4" || list[1].FSName != "/proc" {
+		t.Fatalf("vfs.fs.get = %+v", list)
+	}
+	if list[0].Bytes.Total == 0 || list[0].Bytes.Total != list[1].Bytes.Total {
+		t.Errorf("bytes of / %+v and of /proc %+v, both on the file system of testdata",
+			list[0].Bytes, list[1].Bytes)
+	}
+}
+
+func TestUsageValue(t *testing.T) {
+	u := fsUsage{Total: 1000, Free: 250, Used: 700, PFree: 26.3, PUsed: 73.7}
+	for mode, want := range map[string]interface{}{
+		"": uint64(1000), "total": uint64(1000), "free": uint64(250), "used": uint64(700),
+		"pfree": 26.3, "pused": 73.7,
+	} {
+		if got, err := usageValue([]string{"/", mode}, u); err != nil || got != want {
+			t.Errorf("%s: got %v, %v, want %v", mode, got, err, want)
+		}
+	}
+	if _, err := usageValue([]string{"/", "pfree"}, fsUsage{}); err == nil {
+		t.Error("pfree of an empty file system")
+	}
+}
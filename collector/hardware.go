@@ -0,0 +1,439 @@
+/*******************************************************************************
+* FileName:  hardware.go
+* Author: Victor
+* Date: 2019/09/11 10:20
+* Description: hardware items: system.hw.cpu, chassis, devices and macaddr
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("system.hw.cpu", hwCPU)
+	Register("system.hw.chassis", hwChassis)
+	Register("system.hw.devices", hwDevices)
+	Register("system.hw.macaddr", hwMacaddr)
+}
+
+// cpuInfo holds the fields of one processor of /proc/cpuinfo.
+type cpuInfo struct {
+	n      int
+	fields map[string]string
+}
+
+func readCPUInfo() ([]cpuInfo, error) {
+	lines, err := readLines("proc", "cpuinfo")
+	if err != nil {
+		return nil, err
+	}
+	var cpus []cpuInfo
+	for _, line := range lines {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if key == "processor" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				continue // the summary line of some ARM kernels
+			}
+			cpus = append(cpus, cpuInfo{n, make(map[string]string)})
+			continue
+		}
+		if len(cpus) > 0 {
+			cpus[len(cpus)-1].fields[key] = value
+		}
+	}
+	if len(cpus) == 0 {
+		return nil, fmt.Errorf("/proc/cpuinfo: no processor")
+	}
+	return cpus, nil
+}
+
+// cpuFreq returns the current ("cur") or maximum ("max") frequency of a
+// CPU in Hz from cpufreq, or for the current one from /proc/cpuinfo
+// without it.
+func cpuFreq(c cpuInfo, which string) (uint64, error) {
+	file := "scaling_cur_freq"
+	if which == "max" {
+		file = "cpuinfo_max_freq"
+	}
+	s, err := readString("sys", "devices", "system", "cpu", "cpu"+strconv.Itoa(c.n), "cpufreq", file)
+	if err == nil {
+		khz, err := parseUint(s)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", file, err)
+		}
+		return khz * 1000, nil
+	}
+	if mhz, ok := c.fields["cpu MHz"]; ok && which == "cur" {
+		f, err := strconv.ParseFloat(mhz, 64)
+		if err != nil {
+			return 0, fmt.Errorf("/proc/cpuinfo: %v", err)
+		}
+		return uint64(f * 1e6), nil
+	}
+	return 0, fmt.Errorf("the %s frequency of cpu %d is unknown", which, c.n)
+}
+
+// cpuDescription is the full information of system.hw.cpu on one CPU, such
+// as "processor 0: GenuineIntel Intel(R) Xeon(R) working at 2100MHz
+// (maximum 3000MHz)", leaving out what is unknown.
+func cpuDescription(c cpuInfo) string {
+	s := fmt.Sprintf("processor %d:", c.n)
+	for _, key := range []string{"vendor_id", "model name"} {
+		if v := c.fields[key]; v != "" {
+			s += " " + v
+		}
+	}
+	if f, err := cpuFreq(c, "cur"); err == nil {
+		s += fmt.Sprintf(" working at %dMHz", f/1000000)
+	}
+	if f, err := cpuFreq(c, "max"); err == nil {
+		s += fmt.Sprintf(" (maximum %dMHz)", f/1000000)
+	}
+	return s
+}
+
+// hwCPU is system.hw.cpu[<cpu>,<info>]: the full description (default),
+// vendor, model, current or maximum frequency in Hz of one CPU, or of all
+// (the default) one per line.
+func hwCPU(params []string) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	cpu := -1
+	if p := param(params, 0); p != "" && p != "all" {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid cpu %q", p)
+		}
+		cpu = n
+	}
+	info, err := oneOf(params, 1, "info", "full", "maxfreq", "vendor", "model", "curfreq")
+	if err != nil {
+		return nil, err
+	}
+	cpus, err := readCPUInfo()
+	if err != nil {
+		return nil, err
+	}
+	value := func(c cpuInfo) (interface{}, error) {
+		switch info {
+		case "vendor":
+			return c.fields["vendor_id"], nil
+		case "model":
+			return c.fields["model name"], nil
+		case "curfreq":
+			return cpuFreq(c, "cur")
+		case "maxfreq":
+			return cpuFreq(c, "max")
+		}
+		return cpuDescription(c), nil
+	}
+	var lines []string
+	for _, c := range cpus {
+		if cpu >= 0 {
+			if c.n == cpu {
+				return value(c)
+			}
+			continue
+		}
+		v, err := value(c)
+		if err != nil {
+			return nil, err
+		}
+		if info == "full" {
+			lines = append(lines, v.(string))
+		} else {
+			lines = append(lines, fmt.Sprintf("processor %d: %v", c.n, v))
+		}
+	}
+	if cpu >= 0 {
+		return nil, fmt.Errorf("cpu %d does not exist", cpu)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// chassisTypes names the chassis types of SMBIOS, from 1.
+var chassisTypes = []string{"Other", "Unknown", "Desktop", "Low Profile Desktop", "Pizza Box",
+	"Mini Tower", "Tower", "Portable", "Laptop", "Notebook", "Hand Held", "Docking Station",
+	"All in One", "Sub Notebook", "Space-saving", "Lunch Box", "Main Server Chassis",
+	"Expansion Chassis", "SubChassis", "Bus Expansion Chassis", "Peripheral Chassis",
+	"RAID Chassis", "Rack Mount Chassis", "Sealed-case PC", "Multi-system chassis",
+	"Compact PCI", "Advanced TCA", "Blade", "Blade Enclosure", "Tablet", "Convertible",
+	"Detachable", "IoT Gateway", "Embedded PC", "Mini PC", "Stick PC"}
+
+// dmi returns a file of /sys/class/dmi/id. The serial number is readable
+// by root only.
+func dmi(name string) (string, error) {
+	s, err := readString("sys", "class", "dmi", "id", name)
+	if err != nil {
+		return "", fmt.Errorf("DMI: %v", err)
+	}
+	return s, nil
+}
+
+// hwChassis is system.hw.chassis[<info>]: the vendor, model, serial number
+// and type of the machine, or all of them (full, the default).
+func hwChassis(params []string) (interface{}, error) {
+	if err := maxParams(params, 1); err != nil {
+		return nil, err
+	}
+	info, err := oneOf(params, 0, "info", "full", "model", "serial", "type", "vendor")
+	if err != nil {
+		return nil, err
+	}
+	get := func(info string) (string, error) {
+		switch info {
+		case "model":
+			return dmi("product_name")
+		case "serial":
+			return dmi("product_serial")
+		case "vendor":
+			return dmi("sys_vendor")
+		}
+		s, err := dmi("chassis_type")
+		if err != nil {
+			return "", err
+		}
+		if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= len(chassisTypes) {
+			return chassisTypes[n-1], nil
+		}
+		return s, nil
+	}
+	if info != "full" {
+		return get(info)
+	}
+	var parts []string
+	for _, info := range []string{"vendor", "model", "serial", "type"} {
+		s, err := get(info)
+		if err != nil {
+			if info == "serial" {
+				continue
+			}
+			return nil, err
+		}
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// hwIDs holds the names of a pci.ids or usb.ids database: vendors by id,
+// devices by vendor:device and, for PCI, classes by class and subclass.
+type hwIDs struct {
+	vendors, devices, classes map[string]string
+}
+
+// loadIDs reads the id database name from where distributions put it. A
+// missing database leaves the names unknown.
+func loadIDs(name string) *hwIDs {
+	ids := &hwIDs{make(map[string]string), make(map[string]string), make(map[string]string)}
+	var lines []string
+	for _, dir := range [][]string{{"usr", "share", "hwdata"}, {"usr", "share", "misc"}, {"usr", "share"}} {
+		var err error
+		if lines, err = readLines(append(dir, name)...); err == nil {
+			break
+		}
+	}
+	var vendor, class string
+	for _, line := range lines {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		tabs := len(line) - len(strings.TrimLeft(line, "\t"))
+		f := strings.SplitN(strings.TrimLeft(line, "\t"), "  ", 2)
+		if len(f) != 2 {
+			continue
+		}
+		switch {
+		case tabs == 0 && strings.HasPrefix(f[0], "C "):
+			vendor, class = "", strings.ToLower(f[0][2:])
+			ids.classes[class] = f[1]
+		case tabs == 0 && len(f[0]) == 4:
+			vendor, class = strings.ToLower(f[0]), ""
+			ids.vendors[vendor] = f[1]
+		case tabs == 0: // another section, such as the USB HID codes
+			vendor, class = "", ""
+		case tabs == 1 && vendor != "":
+			ids.devices[vendor+":"+strings.ToLower(f[0])] = f[1]
+		case tabs == 1 && class != "":
+			ids.classes[class+strings.ToLower(f[0])] = f[1]
+		}
+	}
+	return ids
+}
+
+// name returns the vendor and device names of vendor:device, or "Device".
+func (ids *hwIDs) name(vendor, device string) string {
+	v, ok := ids.vendors[vendor]
+	if !ok {
+		return "Device"
+	}
+	if d, ok := ids.devices[vendor+":"+device]; ok {
+		return v + " " + d
+	}
+	return v + " Device"
+}
+
+// sysfsID reads a hexadecimal id of sysfs, dropping its 0x prefix.
+func sysfsID(elem ...string) string {
+	s, _ := readString(elem...)
+	return strings.ToLower(strings.TrimPrefix(s, "0x"))
+}
+
+// listDir returns the names in a directory of the watched host, sorted.
+func listDir(elem ...string) ([]string, error) {
+	infos, err := ioutil.ReadDir(hostPath(elem...))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(infos))
+	for i, fi := range infos {
+		names[i] = fi.Name()
+	}
+	return names, nil
+}
+
+// pciDevices lists the PCI devices as lspci -nn does, e.g. "00:1f.2 SATA
+// controller [0106]: Intel Corporation 82801 SATA Controller [8086:2922]".
+func pciDevices() ([]string, error) {
+	slots, err := listDir("sys", "bus", "pci", "devices")
+	if err != nil {
+		return nil, err
+	}
+	ids := loadIDs("pci.ids")
+	var lines []string
+	for _, slot := range slots {
+		dir := []string{"sys", "bus", "pci", "devices", slot}
+		vendor := sysfsID(append(dir, "vendor")...)
+		device := sysfsID(append(dir, "device")...)
+		class := sysfsID(append(dir, "class")...)
+		if len(class) == 6 {
+			class = class[:4]
+		}
+		className, ok := ids.classes[class]
+		if !ok && len(class) == 4 {
+			className, ok = ids.classes[class[:2]]
+		}
+		if !ok {
+			className = "Class"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s [%s]: %s [%s:%s]",
+			strings.TrimPrefix(slot, "0000:"), className, class, ids.name(vendor, device), vendor, device))
+	}
+	return lines, nil
+}
+
+// usbDevices lists the USB devices as lsusb does, e.g. "Bus 001 Device
+// 002: ID 8087:0024 Intel Corp. Integrated Rate Matching Hub".
+func usbDevices() ([]string, error) {
+	names, err := listDir("sys", "bus", "usb", "devices")
+	if err != nil {
+		return nil, err
+	}
+	ids := loadIDs("usb.ids")
+	var lines []string
+	for _, name := range names {
+		if strings.Contains(name, ":") { // an interface, not a device
+			continue
+		}
+		dir := []string{"sys", "bus", "usb", "devices", name}
+		bus, err1 := readString(append(dir, "busnum")...)
+		dev, err2 := readString(append(dir, "devnum")...)
+		b, err3 := strconv.Atoi(bus)
+		d, err4 := strconv.Atoi(dev)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			continue
+		}
+		vendor := sysfsID(append(dir, "idVendor")...)
+		product := sysfsID(append(dir, "idProduct")...)
+		line := fmt.Sprintf("Bus %03d Device %03d: ID %s:%s", b, d, vendor, product)
+		if v, ok := ids.vendors[vendor]; ok {
+			line += " " + v
+			if p, ok := ids.devices[vendor+":"+product]; ok {
+				line += " " + p
+			}
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	return lines, nil
+}
+
+// hwDevices is system.hw.devices[<type>]: the PCI (default) or USB
+// devices, one per line, named after the pci.ids and usb.ids databases
+// when they are installed.
+func hwDevices(params []string) (interface{}, error) {
+	if err := maxParams(params, 1); err != nil {
+		return nil, err
+	}
+	typ, err := oneOf(params, 0, "type", "pci", "usb")
+	if err != nil {
+		return nil, err
+	}
+	list := pciDevices
+	if typ == "usb" {
+		list = usbDevices
+	}
+	lines, err := list()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// hwMacaddr is system.hw.macaddr[<interface>,<format>]: the MAC addresses
+// of the interfaces matching the regular expression (all by default) as
+// "[eth0] 52:54:00:12:34:56" (full, the default) or only the distinct
+// addresses (short), comma separated. Interfaces without an address, such
+// as the loopback, are left out.
+func hwMacaddr(params []string) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	var re *regexp.Regexp
+	if p := param(params, 0); p != "" && p != "all" {
+		var err error
+		if re, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("invalid interface regular expression: %v", err)
+		}
+	}
+	format, err := oneOf(params, 1, "format", "full", "short")
+	if err != nil {
+		return nil, err
+	}
+	ifaces, err := listDir("sys", "class", "net")
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	seen := make(map[string]bool)
+	for _, iface := range ifaces {
+		if re != nil && !re.MatchString(iface) {
+			continue
+		}
+		addr, err := readString("sys", "class", "net", iface, "address")
+		if err != nil || addr == "" || addr == "00:00:00:00:00:00" {
+			continue
+		}
+		if format == "full" {
+			list = append(list, "["+iface+"] "+addr)
+		} else if !seen[addr] {
+			seen[addr] = true
+			list = append(list, addr)
+		}
+	}
+	return strings.Join(list, ", "), nil
+}
@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHWChassis(t *testing.T) {
+	defer useTestdata()()
+	for key, want := range map[string]string{
+		// product_serial is readable by root only, and left out of full
+		"system.hw.chassis":         "QEMU Standard PC (Q35 + ICH9, 2009) Main Server Chassis",
+		"system.hw.chassis[full]":   "QEMU Standard PC (Q35 + ICH9, 2009) Main Server Chassis",
+		"system.hw.chassis[vendor]": "QEMU",
+		"system.hw.chassis[model]":  "Standard PC (Q35 + ICH9, 2009)",
+		"system.hw.chassis[type]":   "Main Server Chassis",
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	collectErr(t, "system.hw.chassis[serial]")
+	collectErr(t, "system.hw.chassis[bios]")
+}
+
+func TestLoadIDs(t *testing.T) {
+	defer useTestdata()()
+	ids := loadIDs("pci.ids")
+	if v := ids.vendors["8086"]; v != "Intel Corporation" {
+		t.Errorf("vendor 8086 = %q", v)
+	}
+	if d := ids.devices["8086:2930"]; d != "82801I (ICH9 Family) SMBus Controller" {
+		t.Errorf("device 8086:2930 = %q", d)
+	}
+	if c := ids.classes["01"]; c != "Mass storage controller" {
+		t.Errorf("class 01 = %q", c)
+	}
+	if c := ids.classes["0106"]; c != "SATA controller" {
+		t.Errorf("subclass 0106 = %q", c)
+	}
+	// subsystems and programming interfaces are not read
+	if len(ids.devices) != 5 || len(ids.classes) != 11 {
+		t.Errorf("got %d devices and %d classes, want 5 and 11", len(ids.devices), len(ids.classes))
+	}
+	for vendorDevice, want := range map[[2]string]string{
+		{"8086", "29c0"}: "Intel Corporation 82G33/G31/P35/P31 Express DRAM Controller",
+		{"1af4", "1041"}: "Red Hat, Inc. Device",
+		{"1234", "1111"}: "Device",
+	} {
+		if got := ids.name(vendorDevice[0], vendorDevice[1]); got != want {
+			t.Errorf("name(%s:%s) = %q, want %q", vendorDevice[0], vendorDevice[1], got, want)
+		}
+	}
+
+	SetHostRoot(t.Name()) // no pci.ids
+	if ids := loadIDs("pci.ids"); len(ids.vendors) != 0 || ids.name("8086", "29c0") != "Device" {
+		t.Errorf("got %d vendors without pci.ids", len(ids.vendors))
+	}
+}
+
+func TestHWDevices(t *testing.T) {
+	defer useTestdata()()
+	want := strings.Join([]string{
+		"00:00.0 Host bridge [0600]: Intel Corporation 82G33/G31/P35/P31 Express DRAM Controller [8086:29c0]",
+		"00:02.0 VGA compatible controller [0300]: Device [1234:1111]",
+		"00:03.0 Ethernet controller [0200]: Red Hat, Inc. Device [1af4:1041]",
+		"00:04.0 Serial bus controller [0c80]: Intel Corporation 82801I (ICH9 Family) SMBus Controller [8086:2930]",
+		"00:05.0 Class [ff00]: Intel Corporation 82574L Gigabit Network Connection [8086:10d3]",
+		"00:1f.2 SATA controller [0106]: Intel Corporation " +
+			"82801IR/IO/IH (ICH9R/DO/DH) 6 port SATA Controller [AHCI mode] [8086:2922]",
+	}, "\n")
+	for _, key := range []string{"system.hw.devices", "system.hw.devices[pci]"} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s =\n%s\nwant\n%s", key, got, want)
+		}
+	}
+	collectErr(t, "system.hw.devices[usb]") // no /sys/bus/usb
+	collectErr(t, "system.hw.devices[scsi]")
+}
+
+func TestHWMacaddr(t *testing.T) {
+	defer useTestdata()()
+	for key, want := range map[string]string{
+		"system.hw.macaddr":            "[eth0] 52:54:00:12:34:56", // not lo
+		"system.hw.macaddr[all,short]": "52:54:00:12:34:56",
+		"system.hw.macaddr[^lo$]":      "",
+		"system.hw.macaddr[eth,full]":  "[eth0] 52:54:00:12:34:56",
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	collectErr(t, "system.hw.macaddr[(]")
+	collectErr(t, "system.hw.macaddr[,long]")
+}
@@ -0,0 +1,254 @@
+/*******************************************************************************
+* FileName:  host.go
+* Author: Victor
+* Date: 2019/09/03 10:10
+* Description: helpers of the built-in collectors: host root, parameters and
+*              background sampling
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostRoot is the directory holding the proc, sys and etc trees the
+// built-in collectors read: "/" unless the agent watches a host mounted
+// elsewhere, or is pointed at fixture files.
+var hostRoot = "/"
+
+// SetHostRoot makes the built-in collectors read /proc, /sys and /etc
+// below dir. Changing it restarts the samplers, so that no average mixes
+// the samples of two roots.
+func SetHostRoot(dir string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if dir == hostRoot {
+		return
+	}
+	hostRoot = dir
+	for _, s := range samplers {
+		s.reset()
+	}
+}
+
+// hostPath returns the path of a file of the watched host, e.g.
+// hostPath("proc", "stat").
+func hostPath(elem ...string) string {
+	mu.RLock()
+	root := hostRoot
+	mu.RUnlock()
+	return filepath.Join(append([]string{root}, elem...)...)
+}
+
+// readLines returns the lines of a file of the watched host.
+func readLines(elem ...string) ([]string, error) {
+	f, err := os.Open(hostPath(elem...))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
+
+// readString returns the trimmed content of a file of the watched host.
+func readString(elem ...string) (string, error) {
+	lines, err := readLines(elem...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), nil
+}
+
+// param returns parameter i, or "" if it was not given.
+func param(params []string, i int) string {
+	if i < len(params) {
+		return strings.TrimSpace(params[i])
+	}
+	return ""
+}
+
+// maxParams fails keys with more than n parameters.
+func maxParams(params []string, n int) error {
+	if len(params) > n {
+		return fmt.Errorf("too many parameters")
+	}
+	return nil
+}
+
+// oneOf returns the value of parameter i if it is one of values, or the
+// first value if it is empty.
+func oneOf(params []string, i int, what string, values ...string) (string, error) {
+	p := param(params, i)
+	if p == "" {
+		return values[0], nil
+	}
+	for _, v := range values {
+		if p == v {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("invalid %s %q, use %s", what, p, strings.Join(values, ", "))
+}
+
+// parseUint parses a counter read from the kernel.
+func parseUint(s string) (uint64, error) {
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// averages maps the avg1, avg5 and avg15 modes onto their windows.
+var averages = map[string]time.Duration{
+	"avg1":  time.Minute,
+	"avg5":  5 * time.Minute,
+	"avg15": 15 * time.Minute,
+}
+
+// sampler calls collect every second in the background, from its first
+// use on, and keeps the samples of the last 15 minutes so values can be
+// averaged over avg1, avg5 or avg15.
+type sampler struct {
+	name    string // of the sampler in a *PanicError
+	collect func() (interface{}, error)
+
+	once    sync.Once
+	mu      sync.Mutex
+	ready   chan struct{} // closed once there are two samples
+	samples []sample      // ring of the last len(samples) seconds
+	next    int           // index of the next sample
+	n       int           // number of samples taken
+	err     error         // of the last collection
+	gen     int           // incremented by reset
+}
+
+type sample struct {
+	t time.Time
+	v interface{}
+}
+
+// sampleSlots is one sample a second over the longest average, plus one
+// for the start of the window.
+const sampleSlots = 15*60 + 1
+
+// samplers are all the samplers, reset when the host root changes. mu is
+// held to use it.
+var samplers []*sampler
+
+func newSampler(name string, collect func() (interface{}, error)) *sampler {
+	s := &sampler{name: name, collect: collect, ready: make(chan struct{}),
+		samples: make([]sample, sampleSlots)}
+	mu.Lock()
+	defer mu.Unlock()
+	samplers = append(samplers, s)
+	return s
+}
+
+func (s *sampler) start() {
+	s.once.Do(func() {
+		s.take()
+		go func() {
+			for range time.Tick(time.Second) {
+				s.take()
+			}
+		}()
+	})
+}
+
+func (s *sampler) take() {
+	s.mu.Lock()
+	gen := s.gen
+	s.mu.Unlock()
+	v, err := s.collectSafely()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if gen != s.gen { // collected from the previous host root
+		return
+	}
+	s.err = err
+	if err != nil {
+		return
+	}
+	s.samples[s.next] = sample{time.Now(), v}
+	s.next = (s.next + 1) % len(s.samples)
+	s.n++
+	if s.n == 2 {
+		close(s.ready)
+	}
+}
+
+// collectSafely calls collect. The sampler runs on its own rather than
+// in Collect, so it recovers a panic itself: its items then fail with a
+// *PanicError, see SetRecover.
+func (s *sampler) collectSafely() (v interface{}, err error) {
+	mu.RLock()
+	protect := recovering
+	mu.RUnlock()
+	if protect {
+		defer func() {
+			if r := recover(); r != nil {
+				v, err = nil, &PanicError{Key: s.name, Value: r, Stack: debug.Stack()}
+			}
+		}()
+	}
+	return s.collect()
+}
+
+// reset drops the samples taken so far. mu is held.
+func (s *sampler) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.samples {
+		s.samples[i] = sample{}
+	}
+	s.next, s.n, s.err = 0, 0, nil
+	s.ready = make(chan struct{})
+	s.gen++
+}
+
+// window returns the oldest sample taken within d and the latest one,
+// which differ. The first call starts the sampler and waits a second for
+// its second sample.
+func (s *sampler) window(d time.Duration) (first, last sample, err error) {
+	s.start()
+	s.mu.Lock()
+	ready := s.ready
+	s.mu.Unlock()
+	select {
+	case <-ready:
+	case <-time.After(2 * time.Second):
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return first, last, s.err
+	}
+	n := s.n
+	if n > len(s.samples) {
+		n = len(s.samples)
+	}
+	if n < 2 {
+		return first, last, fmt.Errorf("collecting initial data, try again in a second")
+	}
+	at := func(i int) sample { // i-th latest sample, 0 the latest
+		return s.samples[(s.next-1-i+2*len(s.samples))%len(s.samples)]
+	}
+	last = at(0)
+	first = at(1)
+	for i := 2; i < n && last.t.Sub(at(i).t) <= d; i++ {
+		first = at(i)
+	}
+	return first, last, nil
+}
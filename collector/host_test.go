@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+// A panic of a sampler fails its items rather than the agent.
+func TestSamplerPanic(t *testing.T) {
+	SetRecover(true)
+	defer SetRecover(false)
+	s := newSampler("test sampler", func() (interface{}, error) { panic("bad /proc/stat") })
+	s.once.Do(func() {}) // no background sampling
+	s.take()
+	pe, ok := s.err.(*PanicError)
+	if !ok {
+		t.Fatalf("got %v, want a *PanicError", s.err)
+	}
+	if pe.Key != "test sampler" || pe.Value != "bad /proc/stat" || len(pe.Stack) == 0 {
+		t.Errorf("got %+v", pe)
+	}
+}
+
+func TestSamplerReset(t *testing.T) {
+	now := time.Now()
+	s := fedSampler(sample{now.Add(-time.Second), 1}, sample{now, 2})
+	SetHostRoot("/") // unchanged: the samples stay
+	if s.n != 2 {
+		t.Fatalf("%d samples after setting the same root", s.n)
+	}
+
+	SetHostRoot("testdata")
+	defer SetHostRoot("/")
+	if s.n != 0 || s.err != nil {
+		t.Errorf("%d samples, error %v after changing the root", s.n, s.err)
+	}
+	select {
+	case <-s.ready:
+		t.Error("ready after changing the root")
+	default:
+	}
+
+	// a sample collected while the root changes is dropped
+	s = newSampler("test sampler", func() (interface{}, error) {
+		SetHostRoot("/")
+		return 1, nil
+	})
+	s.take()
+	if s.n != 0 {
+		t.Errorf("kept a sample of the previous root")
+	}
+}
@@ -0,0 +1,104 @@
+/*******************************************************************************
+* FileName:  key.go
+* Author: Victor
+* Date: 2019/08/27 10:20
+* Description: parsing of zabbix item keys, e.g. vfs.fs.size[/,pfree]
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseKey splits an item key into its name and parameters. Parameters may
+// be quoted ("a,b" with \" escapes) or unquoted; an unquoted parameter
+// may itself be an array such as [a,b], which is returned as written.
+func ParseKey(key string) (name string, params []string, err error) {
+	i := strings.IndexByte(key, '[')
+	if i < 0 {
+		if !validName(key) {
+			return "", nil, fmt.Errorf("invalid item key %q", key)
+		}
+		return key, nil, nil
+	}
+	name = key[:i]
+	if !validName(name) || key[len(key)-1] != ']' {
+		return "", nil, fmt.Errorf("invalid item key %q", key)
+	}
+	params, err = splitParams(key[i+1 : len(key)-1])
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid item key %q: %v", key, err)
+	}
+	return name, params, nil
+}
+
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func splitParams(s string) ([]string, error) {
+	var params []string
+	for pos := 0; ; {
+		for pos < len(s) && s[pos] == ' ' {
+			pos++
+		}
+		var param string
+		switch {
+		case pos < len(s) && s[pos] == '"':
+			var b strings.Builder
+			pos++
+			for ; pos < len(s) && s[pos] != '"'; pos++ {
+				if s[pos] == '\\' && pos+1 < len(s) && s[pos+1] == '"' {
+					pos++
+				}
+				b.WriteByte(s[pos])
+			}
+			if pos == len(s) {
+				return nil, fmt.Errorf("unterminated quoted parameter")
+			}
+			pos++
+			for pos < len(s) && s[pos] == ' ' {
+				pos++
+			}
+			param = b.String()
+		case pos < len(s) && s[pos] == '[':
+			end := strings.IndexByte(s[pos:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated array parameter")
+			}
+			param = s[pos : pos+end+1]
+			pos += end + 1
+		default:
+			end := strings.IndexByte(s[pos:], ',')
+			if end < 0 {
+				end = len(s) - pos
+			}
+			param = strings.TrimRight(s[pos:pos+end], " ")
+			if strings.ContainsAny(param, "[]") {
+				return nil, fmt.Errorf("unexpected bracket in parameter %q", param)
+			}
+			pos += end
+		}
+		params = append(params, param)
+		if pos == len(s) {
+			return params, nil
+		}
+		if s[pos] != ',' {
+			return nil, fmt.Errorf("expected ',' at %q", s[pos:])
+		}
+		pos++
+	}
+}
@@ -0,0 +1,281 @@
+/*******************************************************************************
+* FileName:  memory.go
+* Author: Victor
+* Date: 2019/09/04 10:15
+* Description: memory and swap items: vm.memory.size, system.swap.size, in
+*              and out
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"fmt"
+	"strings"
+)
+
+func init() {
+	Register("vm.memory.size", memorySize)
+	Register("system.swap.size", swapSize)
+	Register("system.swap.in", func(params []string) (interface{}, error) {
+		return swapIO(params, true)
+	})
+	Register("system.swap.out", func(params []string) (interface{}, error) {
+		return swapIO(params, false)
+	})
+}
+
+// readMeminfo returns the values of /proc/meminfo in bytes, by name.
+func readMeminfo() (map[string]uint64, error) {
+	lines, err := readLines("proc", "meminfo")
+	if err != nil {
+		return nil, err
+	}
+	info := make(map[string]uint64, len(lines))
+	for _, line := range lines {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		f := strings.Fields(line[i+1:])
+		if len(f) == 0 {
+			continue
+		}
+		v, err := parseUint(f[0])
+		if err != nil {
+			continue
+		}
+		if len(f) > 1 && f[1] == "kB" {
+			v *= 1024
+		}
+		info[line[:i]] = v
+	}
+	if _, ok := info["MemTotal"]; !ok {
+		return nil, fmt.Errorf("/proc/meminfo: no MemTotal")
+	}
+	return info, nil
+}
+
+// memoryModes are the modes of vm.memory.size; the first is the default.
+var memoryModes = []string{"total", "free", "used", "pused", "available", "pavailable",
+	"buffers", "cached", "shared", "active", "inactive", "slab"}
+
+// memorySize is vm.memory.size[<mode>], in bytes or, for the p modes, as a
+// percentage of the total. Used memory is what is not available.
+func memorySize(params []string) (interface{}, error) {
+	if err := maxParams(params, 1); err != nil {
+		return nil, err
+	}
+	mode, err := oneOf(params, 0, "mode", memoryModes...)
+	if err != nil {
+		return nil, err
+	}
+	info, err := readMeminfo()
+	if err != nil {
+		return nil, err
+	}
+	total := info["MemTotal"]
+	available, ok := info["MemAvailable"]
+	if !ok { // before Linux 3.14
+		available = info["MemFree"] + info["Buffers"] + info["Cached"]
+	}
+	if available > total {
+		available = total
+	}
+	percent := func(v uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return 100 * float64(v) / float64(total)
+	}
+	switch mode {
+	case "total":
+		return total, nil
+	case "free":
+		return info["MemFree"], nil
+	case "used":
+		return total - available, nil
+	case "pused":
+		return percent(total - available), nil
+	case "available":
+		return available, nil
+	case "pavailable":
+		return percent(available), nil
+	case "buffers":
+		return info["Buffers"], nil
+	case "cached":
+		return info["Cached"], nil
+	case "shared":
+		return info["Shmem"], nil
+	case "active":
+		return info["Active"], nil
+	case "inactive":
+		return info["Inactive"], nil
+	}
+	return info["Slab"], nil
+}
+
+// swapDevice is one line of /proc/swaps, sizes in bytes.
+type swapDevice struct {
+	name       string
+	partition  bool
+	size, used uint64
+}
+
+func readSwaps() ([]swapDevice, error) {
+	lines, err := readLines("proc", "swaps")
+	if err != nil {
+		return nil, err
+	}
+	var devs []swapDevice
+	for i, line := range lines {
+		f := strings.Fields(line)
+		if i == 0 || len(f) < 4 { // the header or a broken line
+			continue
+		}
+		size, err1 := parseUint(f[2])
+		used, err2 := parseUint(f[3])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		devs = append(devs, swapDevice{f[0], f[1] == "partition", size * 1024, used * 1024})
+	}
+	return devs, nil
+}
+
+// swapSize is system.swap.size[<device>,<type>]: free (default), total,
+// used, pfree or pused swap space of one device or of all.
+func swapSize(params []string) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	mode, err := oneOf(params, 1, "type", "free", "total", "used", "pfree", "pused")
+	if err != nil {
+		return nil, err
+	}
+	var total, free uint64
+	if dev := param(params, 0); dev == "" || dev == "all" {
+		info, err := readMeminfo()
+		if err != nil {
+			return nil, err
+		}
+		total, free = info["SwapTotal"], info["SwapFree"]
+	} else {
+		devs, err := readSwaps()
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, d := range devs {
+			if d.name == dev || devName(d.name) == devName(dev) {
+				total, free, found = d.size, d.size-d.used, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not a swap device", dev)
+		}
+	}
+	percent := func(v uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return 100 * float64(v) / float64(total)
+	}
+	switch mode {
+	case "total":
+		return total, nil
+	case "used":
+		return total - free, nil
+	case "pfree":
+		return percent(free), nil
+	case "pused":
+		return percent(total - free), nil
+	}
+	return free, nil
+}
+
+// swapIO is system.swap.in and system.swap.out[<device>,<type>]: the
+// pages swapped in or out for all devices (pages, the default as in
+// Zabbix), or the number of swap reads or writes (count) or of sectors
+// moved, summed over the swap partitions or for one device.
+func swapIO(params []string, in bool) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	mode, err := oneOf(params, 1, "type", "pages", "count", "sectors")
+	if err != nil {
+		return nil, err
+	}
+	dev := param(params, 0)
+	if dev == "all" {
+		dev = ""
+	}
+	if mode == "pages" {
+		if dev != "" {
+			return nil, fmt.Errorf("pages are only counted for all devices, use count or sectors for %s", dev)
+		}
+		name := "pswpout"
+		if in {
+			name = "pswpin"
+		}
+		return vmstat(name)
+	}
+
+	var names []string
+	if dev != "" {
+		names = []string{devName(dev)}
+	} else {
+		devs, err := readSwaps()
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range devs {
+			if d.partition {
+				names = append(names, devName(d.name))
+			}
+		}
+	}
+	stats, err := readDiskstats()
+	if err != nil {
+		return nil, err
+	}
+	var sum uint64
+	for _, name := range names {
+		found := false
+		for _, s := range stats {
+			if s.name != name {
+				continue
+			}
+			found = true
+			switch {
+			case in && mode == "count":
+				sum += s.reads
+			case in:
+				sum += s.readSectors
+			case mode == "count":
+				sum += s.writes
+			default:
+				sum += s.writeSectors
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("device %s not found in /proc/diskstats", name)
+		}
+	}
+	return sum, nil
+}
+
+// vmstat returns the counter name of /proc/vmstat.
+func vmstat(name string) (uint64, error) {
+	lines, err := readLines("proc", "vmstat")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range lines {
+		f := strings.Fields(line)
+		if len(f) == 2 && f[0] == name {
+			return parseUint(f[1])
+		}
+	}
+	return 0, fmt.Errorf("/proc/vmstat: no %s", name)
+}
@@ -0,0 +1,69 @@
+package collector
+
+import "testing"
+
+func TestMemorySize(t *testing.T) {
+	defer useTestdata()()
+	for key, want := range map[string]uint64{
+		"vm.memory.size":            1024000, // total
+		"vm.memory.size[total]":     1024000,
+		"vm.memory.size[free]":      204800,
+		"vm.memory.size[used]":      409600, // total - available
+		"vm.memory.size[available]": 614400,
+		"vm.memory.size[buffers]":   51200,
+		"vm.memory.size[cached]":    256000,
+		"vm.memory.size[shared]":    10240,
+		"vm.memory.size[slab]":      30720,
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %v (%T), want %d", key, got, got, want)
+		}
+	}
+	checkFloat(t, "vm.memory.size[pused]", collect(t, "vm.memory.size[pused]"), 40)
+	checkFloat(t, "vm.memory.size[pavailable]", collect(t, "vm.memory.size[pavailable]"), 60)
+	collectErr(t, "vm.memory.size[wired]")
+}
+
+func TestSwapSize(t *testing.T) {
+	defer useTestdata()()
+	for key, want := range map[string]uint64{
+		"system.swap.size":                 1048576, // all, free
+		"system.swap.size[all,total]":      2097152,
+		"system.swap.size[,used]":          1048576,
+		"system.swap.size[/dev/sda2,free]": 786432,
+		"system.swap.size[sda2,total]":     1048576,
+		"system.swap.size[/swapfile,used]": 786432,
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %v (%T), want %d", key, got, got, want)
+		}
+	}
+	checkFloat(t, "system.swap.size[,pfree]", collect(t, "system.swap.size[,pfree]"), 50)
+	checkFloat(t, "system.swap.size[sda2,pused]", collect(t, "system.swap.size[sda2,pused]"), 25)
+	collectErr(t, "system.swap.size[sdb1]")
+	collectErr(t, "system.swap.size[,max]")
+}
+
+func TestSwapIO(t *testing.T) {
+	defer useTestdata()()
+	for key, want := range map[string]uint64{
+		"system.swap.in":                    111, // all, pages
+		"system.swap.in[,count]":            10,  // the sda2 partition
+		"system.swap.in[all,sectors]":       80,
+		"system.swap.in[/dev/sda2,count]":   10,
+		"system.swap.in[all,pages]":         111,
+		"system.swap.out":                   222,
+		"system.swap.out[,count]":           20,
+		"system.swap.out[sda2,sectors]":     160,
+		"system.swap.out[,pages]":           222,
+		"system.swap.out[/dev/sda,sectors]": 16000,
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %v (%T), want %d", key, got, got, want)
+		}
+	}
+	collectErr(t, "system.swap.in[/dev/sda2,pages]")
+	collectErr(t, "system.swap.in[/dev/sda2]") // pages
+	collectErr(t, "system.swap.out[sdb1]")
+	collectErr(t, "system.swap.out[,bytes]")
+}
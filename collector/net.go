@@ -0,0 +1,232 @@
+/*******************************************************************************
+* FileName:  net.go
+* Author: Victor
+* Date: 2019/09/05 14:10
+* Description: network interface items: net.if.in, out, total, collisions
+*              and net.if.discovery
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"../pkg"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+func init() {
+	Register("net.if.in", func(params []string) (interface{}, error) {
+		return ifCounter(params, ifInModes, 0)
+	})
+	Register("net.if.out", func(params []string) (interface{}, error) {
+		return ifCounter(params, ifOutModes, 8)
+	})
+	Register("net.if.total", netIfTotal)
+	Register("net.if.collisions", func(params []string) (interface{}, error) {
+		if err := maxParams(params, 1); err != nil {
+			return nil, err
+		}
+		return ifColumn(param(params, 0), 8+5)
+	})
+	Register("net.if.discovery", netIfDiscovery)
+}
+
+// ifInModes and ifOutModes are the columns of /proc/net/dev for received
+// and sent traffic, in order, by their net.if.in and net.if.out mode.
+var (
+	ifInModes = []string{"bytes", "packets", "errors", "dropped", "overruns",
+		"frame", "compressed", "multicast"}
+	ifOutModes = []string{"bytes", "packets", "errors", "dropped", "overruns",
+		"collisions", "carrier", "compressed"}
+)
+
+// ifTotalModes are the modes of net.if.total, counted both ways.
+var ifTotalModes = []string{"bytes", "packets", "errors", "dropped", "overruns", "compressed"}
+
+// ifCounters are the 16 counters of an interface line of /proc/net/dev,
+// received then sent.
+type ifCounters [16]uint64
+
+// ifState follows the counters of one interface between reads, so they
+// keep growing when the kernel counters wrap.
+type ifState struct {
+	name  string
+	last  ifCounters // as last read
+	total ifCounters // 64-bit counters returned by the items
+}
+
+// ifCounters32 tells whether the kernel counters of /proc/net/dev are 32
+// bits wide, as on 32-bit kernels: a counter going down has wrapped then,
+// while on a 64-bit kernel it was reset, e.g. by reloading the driver.
+var ifCounters32 = !kernel64()
+
+// kernel64 reports whether the kernel is 64-bit, from its machine name:
+// x86_64, aarch64, ppc64le, s390x and so on.
+func kernel64() bool {
+	var u syscall.Utsname
+	if err := syscall.Uname(&u); err != nil {
+		return strconv.IntSize == 64
+	}
+	machine := utsString(unsafe.Pointer(&u.Machine))
+	return strings.Contains(machine, "64") || machine == "s390x"
+}
+
+// ifStates are keyed by interface index: a renamed interface keeps its
+// counters, and one taking the name of another starts afresh. Without
+// sysfs they are keyed by name.
+var (
+	ifMu     sync.Mutex
+	ifStates = map[string]*ifState{}
+)
+
+// readNetDev reads /proc/net/dev, updates ifStates and returns the
+// counters of each interface by name.
+func readNetDev() (map[string]ifCounters, error) {
+	lines, err := readLines("proc", "net", "dev")
+	if err != nil {
+		return nil, err
+	}
+	ifMu.Lock()
+	defer ifMu.Unlock()
+	seen := make(map[string]bool, len(ifStates))
+	counters := make(map[string]ifCounters)
+	for _, line := range lines {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:i])
+		f := strings.Fields(line[i+1:])
+		if name == "" || len(f) < 16 {
+			continue
+		}
+		var raw ifCounters
+		for j := range raw {
+			if raw[j], err = parseUint(f[j]); err != nil {
+				return nil, fmt.Errorf("/proc/net/dev: %s: %v", name, err)
+			}
+		}
+		key := "name:" + name
+		if index, err := readString("sys", "class", "net", name, "ifindex"); err == nil {
+			key = index
+		}
+		seen[key] = true
+		st, ok := ifStates[key]
+		if !ok {
+			ifStates[key] = &ifState{name, raw, raw}
+			counters[name] = raw
+			continue
+		}
+		st.name = name
+		for j, v := range raw {
+			switch {
+			case v >= st.last[j]:
+				st.total[j] += v - st.last[j]
+			case ifCounters32 && st.last[j] <= math.MaxUint32: // wrapped
+				st.total[j] += v + (math.MaxUint32 + 1) - st.last[j]
+			default: // reset
+				st.total[j] += v
+			}
+		}
+		st.last = raw
+		counters[name] = st.total
+	}
+	for key := range ifStates {
+		if !seen[key] {
+			delete(ifStates, key)
+		}
+	}
+	return counters, nil
+}
+
+// ifColumn returns column i of the counters of interface name.
+func ifColumn(name string, i int) (uint64, error) {
+	if name == "" {
+		return 0, fmt.Errorf("interface not given")
+	}
+	counters, err := readNetDev()
+	if err != nil {
+		return 0, err
+	}
+	c, ok := counters[name]
+	if !ok {
+		return 0, fmt.Errorf("interface %s not found in /proc/net/dev", name)
+	}
+	return c[i], nil
+}
+
+// ifCounter is net.if.in and net.if.out[if,<mode>]: the counter of mode
+// (bytes by default) of the received or sent traffic, whose columns start
+// at offset.
+func ifCounter(params []string, modes []string, offset int) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	mode, err := oneOf(params, 1, "mode", modes...)
+	if err != nil {
+		return nil, err
+	}
+	i := 0
+	for modes[i] != mode {
+		i++
+	}
+	return ifColumn(param(params, 0), offset+i)
+}
+
+// netIfTotal is net.if.total[if,<mode>]: the sum of the received and sent
+// counters of mode, bytes by default.
+func netIfTotal(params []string) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	mode, err := oneOf(params, 1, "mode", ifTotalModes...)
+	if err != nil {
+		return nil, err
+	}
+	name := param(params, 0)
+	if name == "" {
+		return nil, fmt.Errorf("interface not given")
+	}
+	counters, err := readNetDev()
+	if err != nil {
+		return nil, err
+	}
+	c, ok := counters[name]
+	if !ok {
+		return nil, fmt.Errorf("interface %s not found in /proc/net/dev", name)
+	}
+	in, out := 0, 8
+	for ifInModes[in] != mode {
+		in++
+	}
+	for ifOutModes[out-8] != mode {
+		out++
+	}
+	return c[in] + c[out], nil
+}
+
+// netIfDiscovery is net.if.discovery: {#IFNAME} of every interface of
+// /proc/net/dev.
+func netIfDiscovery(params []string) (interface{}, error) {
+	if err := maxParams(params, 0); err != nil {
+		return nil, err
+	}
+	lines, err := readLines("proc", "net", "dev")
+	if err != nil {
+		return nil, err
+	}
+	d := pkg.DiscoveryData{Data: make([]interface{}, 0, len(lines))}
+	for _, line := range lines {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		d.Data = append(d.Data, map[string]string{"{#IFNAME}": strings.TrimSpace(line[:i])})
+	}
+	return d, nil
+}
@@ -0,0 +1,181 @@
+package collector
+
+import (
+	"../pkg"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// resetIfStates forgets the counters followed so far.
+func resetIfStates() {
+	ifMu.Lock()
+	defer ifMu.Unlock()
+	ifStates = map[string]*ifState{}
+}
+
+func TestNetIf(t *testing.T) {
+	defer useTestdata()()
+	resetIfStates()
+	for key, want := range map[string]uint64{
+		"net.if.in[eth0]":             5000,
+		"net.if.in[eth0,errors]":      1,
+		"net.if.in[eth0,multicast]":   6,
+		"net.if.out[eth0]":            7000,
+		"net.if.out[eth0,collisions]": 10,
+		"net.if.out[eth0,compressed]": 12,
+		"net.if.total[eth0]":          12000,
+		"net.if.total[eth0,dropped]":  10,
+		"net.if.total[eth0,overruns]": 12,
+		"net.if.collisions[eth0]":     10,
+		"net.if.total[lo,packets]":    20,
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %v (%T), want %d", key, got, got, want)
+		}
+	}
+	for _, key := range []string{
+		"net.if.in",
+		"net.if.in[eth1]",
+		"net.if.in[eth0,colls]",
+		"net.if.total[eth0,multicast]",
+		"net.if.collisions[]",
+	} {
+		collectErr(t, key)
+	}
+
+	got := collect(t, "net.if.discovery").(pkg.DiscoveryData).Data
+	want := []interface{}{map[string]string{"{#IFNAME}": "lo"}, map[string]string{"{#IFNAME}": "eth0"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("net.if.discovery = %v, want %v", got, want)
+	}
+}
+
+// netRoot is a host root whose /proc/net/dev and interface indexes a test
+// changes between reads.
+type netRoot struct {
+	t   *testing.T
+	dir string
+}
+
+func newNetRoot(t *testing.T) (*netRoot, func()) {
+	dir, err := ioutil.TempDir("", "netdev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetHostRoot(dir)
+	resetIfStates()
+	return &netRoot{t, dir}, func() {
+		SetHostRoot("/")
+		os.RemoveAll(dir)
+	}
+}
+
+// iface is an interface as set by netRoot.set.
+type iface struct {
+	name    string
+	index   int
+	inBytes uint64
+}
+
+// set writes the interfaces, with their received bytes, and reads them.
+func (r *netRoot) set(ifaces ...iface) {
+	r.t.Helper()
+	os.RemoveAll(filepath.Join(r.dir, "sys"))
+	dev := "Inter-|   Receive\n face |bytes\n"
+	for _, i := range ifaces {
+		dev += fmt.Sprintf("%6s: %d 1 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n", i.name, i.inBytes)
+		dir := filepath.Join(r.dir, "sys", "class", "net", i.name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "ifindex"), []byte(fmt.Sprintln(i.index)), 0644); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(r.dir, "proc", "net"), 0755); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(r.dir, "proc", "net", "dev"), []byte(dev), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+	if _, err := readNetDev(); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func (r *netRoot) check(name string, want uint64) {
+	r.t.Helper()
+	key := "net.if.in[" + name + "]"
+	if got := collect(r.t, key); got != want {
+		r.t.Errorf("%s = %v, want %d", key, got, want)
+	}
+}
+
+// withCounters32 sets whether the counters of /proc/net/dev are 32-bit
+// until the returned function is called.
+func withCounters32(on bool) func() {
+	saved := ifCounters32
+	ifCounters32 = on
+	return func() { ifCounters32 = saved }
+}
+
+func TestNetIfWrap(t *testing.T) {
+	r, done := newNetRoot(t)
+	defer done()
+	defer withCounters32(true)()
+	r.set(iface{"eth0", 2, 4294967000})
+	r.set(iface{"eth0", 2, 100})
+	r.check("eth0", 1<<32+100)
+}
+
+func TestNetIfReset(t *testing.T) {
+	r, done := newNetRoot(t)
+	defer done()
+	defer withCounters32(false)()
+	// a 64-bit counter reset below 4 GiB is not taken for a wrap
+	r.set(iface{"eth0", 2, 3000000000})
+	r.set(iface{"eth0", 2, 500})
+	r.check("eth0", 3000000500)
+	r.set(iface{"eth0", 2, 600})
+	r.check("eth0", 3000000600)
+}
+
+func TestNetIfRename(t *testing.T) {
+	r, done := newNetRoot(t)
+	defer done()
+	defer withCounters32(false)()
+	r.set(iface{"eth0", 2, 3000})
+	r.set(iface{"eth0", 2, 100}) // reset, counted as 3100
+	// renamed, same index: the counters go on
+	r.set(iface{"lan0", 2, 200})
+	r.check("lan0", 3200)
+	collectErr(t, "net.if.in[eth0]")
+	// another interface taking the old name starts afresh
+	r.set(iface{"lan0", 2, 300}, iface{"eth0", 5, 40})
+	r.check("eth0", 40)
+	r.check("lan0", 3300)
+}
+
+func TestNetIfStale(t *testing.T) {
+	r, done := newNetRoot(t)
+	defer done()
+	defer withCounters32(false)()
+	r.set(iface{"eth0", 2, 1000}, iface{"veth1", 7, 5000})
+	r.set(iface{"eth0", 2, 1100}, iface{"veth1", 7, 10})
+	r.check("veth1", 5010)
+	// veth1 goes away and its state with it
+	r.set(iface{"eth0", 2, 1200})
+	ifMu.Lock()
+	n := len(ifStates)
+	ifMu.Unlock()
+	if n != 1 {
+		t.Errorf("%d interfaces followed, want 1", n)
+	}
+	// a new interface reusing the index does not inherit the counters
+	r.set(iface{"eth0", 2, 1300}, iface{"veth2", 7, 20})
+	r.check("veth2", 20)
+}
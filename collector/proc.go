@@ -0,0 +1,531 @@
+/*******************************************************************************
+* FileName:  proc.go
+* Author: Victor
+* Date: 2019/09/09 10:40
+* Description: process items: proc.num, proc.mem, proc.cpu.util and proc.get
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("proc.num", procNum)
+	Register("proc.mem", procMem)
+	Register("proc.cpu.util", procCPUUtil)
+	Register("proc.get", procGet)
+}
+
+// clockTicks is USER_HZ, the unit of the CPU times of /proc/<pid>/stat,
+// which Linux fixes at 100 for user space.
+const clockTicks = 100
+
+// procStates map the states of proc.num onto those of /proc/<pid>/stat.
+var procStates = map[string]string{
+	"R": "run", "S": "sleep", "D": "disk", "T": "trace", "t": "trace", "Z": "zomb",
+}
+
+// process holds what the items need of one process.
+type process struct {
+	pid, ppid    int
+	name         string // comm, at most 15 characters
+	argv0        string // base name of the first argument
+	cmdline      string // arguments joined by spaces
+	state        string // run, sleep, disk, trace, zomb or other
+	uid          int
+	threads      uint64
+	utime, stime uint64 // in clock ticks
+	start        uint64 // in clock ticks after boot
+	vm           map[string]uint64
+}
+
+// readStat parses /proc/<pid>/stat, returning the command name and the
+// fields following it, the first being the state.
+func readStat(pid string) (name string, fields []string, err error) {
+	s, err := readString("proc", pid, "stat")
+	if err != nil {
+		return "", nil, err
+	}
+	i, j := strings.IndexByte(s, '('), strings.LastIndexByte(s, ')')
+	if i < 0 || j < i {
+		return "", nil, fmt.Errorf("/proc/%s/stat: unexpected content", pid)
+	}
+	fields = strings.Fields(s[j+1:])
+	if len(fields) < 22 {
+		return "", nil, fmt.Errorf("/proc/%s/stat: unexpected content", pid)
+	}
+	return s[i+1 : j], fields, nil
+}
+
+// readProcess reads /proc/<pid>/stat, status and cmdline.
+func readProcess(pid string) (*process, error) {
+	name, f, err := readStat(pid)
+	if err != nil {
+		return nil, err
+	}
+	p := &process{name: name, uid: -1, vm: make(map[string]uint64)}
+	p.pid, _ = strconv.Atoi(pid)
+	p.ppid, _ = strconv.Atoi(f[1])
+	p.state = procStates[f[0]]
+	if p.state == "" {
+		p.state = "other"
+	}
+	p.utime, _ = parseUint(f[11])
+	p.stime, _ = parseUint(f[12])
+	p.threads, _ = parseUint(f[17])
+	p.start, _ = parseUint(f[19])
+
+	lines, err := readLines("proc", pid, "status")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		v := strings.Fields(line[i+1:])
+		switch key := line[:i]; {
+		case key == "Uid" && len(v) > 0:
+			p.uid, _ = strconv.Atoi(v[0])
+		case strings.HasPrefix(key, "Vm") && len(v) > 0:
+			n, err := parseUint(v[0])
+			if err == nil {
+				p.vm[key] = n * 1024
+			}
+		}
+	}
+
+	b, err := readString("proc", pid, "cmdline")
+	if err != nil {
+		return nil, err
+	}
+	if b = strings.TrimRight(b, "\x00"); b != "" { // kernel threads have none
+		args := strings.Split(b, "\x00")
+		p.argv0 = filepath.Base(args[0])
+		p.cmdline = strings.Join(args, " ")
+	}
+	return p, nil
+}
+
+// listPIDs returns the process directories of /proc.
+func listPIDs() ([]string, error) {
+	d, err := os.Open(hostPath("proc"))
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	names, err := d.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	pids := names[:0]
+	for _, name := range names {
+		if _, err := strconv.Atoi(name); err == nil {
+			pids = append(pids, name)
+		}
+	}
+	return pids, nil
+}
+
+// procFilter selects processes by the name, user and cmdline parameters
+// common to the process items.
+type procFilter struct {
+	name    string
+	uid     int // -1 for any user
+	cmdline *regexp.Regexp
+}
+
+// newProcFilter parses the name, user and cmdline parameters at the given
+// indexes; empty ones match every process.
+func newProcFilter(params []string, name, user, cmdline int) (*procFilter, error) {
+	f := &procFilter{name: param(params, name), uid: -1}
+	if u := param(params, user); u != "" {
+		uid, err := lookupUser(u)
+		if err != nil {
+			return nil, err
+		}
+		f.uid = uid
+	}
+	if c := param(params, cmdline); c != "" {
+		re, err := regexp.Compile(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cmdline regular expression: %v", err)
+		}
+		f.cmdline = re
+	}
+	return f, nil
+}
+
+// match matches the name against comm or, as comm is cut to 15
+// characters, the base name of the first argument.
+func (f *procFilter) match(p *process) bool {
+	if f.name != "" && f.name != p.name && f.name != p.argv0 {
+		return false
+	}
+	if f.uid >= 0 && f.uid != p.uid {
+		return false
+	}
+	return f.cmdline == nil || f.cmdline.MatchString(p.cmdline)
+}
+
+// processes returns the processes f matches. Processes exiting while they
+// are read are left out.
+func (f *procFilter) processes() ([]*process, error) {
+	pids, err := listPIDs()
+	if err != nil {
+		return nil, err
+	}
+	var procs []*process
+	for _, pid := range pids {
+		p, err := readProcess(pid)
+		if err != nil {
+			continue
+		}
+		if f.match(p) {
+			procs = append(procs, p)
+		}
+	}
+	return procs, nil
+}
+
+// readPasswd returns the users of the watched host by name.
+func readPasswd() (map[string]int, error) {
+	lines, err := readLines("etc", "passwd")
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]int, len(lines))
+	for _, line := range lines {
+		f := strings.Split(line, ":")
+		if len(f) < 3 {
+			continue
+		}
+		if uid, err := strconv.Atoi(f[2]); err == nil {
+			users[f[0]] = uid
+		}
+	}
+	return users, nil
+}
+
+// lookupUser returns the uid of a user name or number.
+func lookupUser(user string) (int, error) {
+	if uid, err := strconv.Atoi(user); err == nil && uid >= 0 {
+		return uid, nil
+	}
+	users, err := readPasswd()
+	if err != nil {
+		return 0, err
+	}
+	uid, ok := users[user]
+	if !ok {
+		return 0, fmt.Errorf("unknown user %q", user)
+	}
+	return uid, nil
+}
+
+// checkZone accepts the zone parameter of the Solaris agent, which means
+// nothing on Linux.
+func checkZone(params []string, i int) error {
+	_, err := oneOf(params, i, "zone", "current", "all")
+	return err
+}
+
+// procNum is proc.num[<name>,<user>,<state>,<cmdline>,<zone>]: the number
+// of processes matching, in state (all by default).
+func procNum(params []string) (interface{}, error) {
+	if err := maxParams(params, 5); err != nil {
+		return nil, err
+	}
+	state, err := oneOf(params, 2, "state", "all", "disk", "run", "sleep", "trace", "zomb")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkZone(params, 4); err != nil {
+		return nil, err
+	}
+	f, err := newProcFilter(params, 0, 1, 3)
+	if err != nil {
+		return nil, err
+	}
+	procs, err := f.processes()
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, p := range procs {
+		if state == "all" || p.state == state {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// procMemTypes map the memory types of proc.mem onto the fields of
+// /proc/<pid>/status. size is data, stack and text; pmem is rss as a
+// percentage of the memory.
+var procMemTypes = map[string][]string{
+	"vsize": {"VmSize"}, "rss": {"VmRSS"}, "pmem": {"VmRSS"},
+	"size": {"VmData", "VmStk", "VmExe"},
+	"peak": {"VmPeak"}, "swap": {"VmSwap"}, "lib": {"VmLib"}, "lck": {"VmLck"},
+	"pin": {"VmPin"}, "data": {"VmData"}, "exe": {"VmExe"}, "stk": {"VmStk"},
+	"hwm": {"VmHWM"}, "pte": {"VmPTE"},
+}
+
+// procMem is proc.mem[<name>,<user>,<mode>,<cmdline>,<memtype>]: the sum
+// (default), average, maximum or minimum of memtype (vsize by default) in
+// bytes over the processes matching. Kernel threads have no memory of
+// their own and are left out.
+func procMem(params []string) (interface{}, error) {
+	if err := maxParams(params, 5); err != nil {
+		return nil, err
+	}
+	mode, err := oneOf(params, 2, "mode", "sum", "avg", "max", "min")
+	if err != nil {
+		return nil, err
+	}
+	memtype := param(params, 4)
+	if memtype == "" {
+		memtype = "vsize"
+	}
+	fields, ok := procMemTypes[memtype]
+	if !ok {
+		return nil, fmt.Errorf("invalid memtype %q", memtype)
+	}
+	f, err := newProcFilter(params, 0, 1, 3)
+	if err != nil {
+		return nil, err
+	}
+	procs, err := f.processes()
+	if err != nil {
+		return nil, err
+	}
+	var values []uint64
+	for _, p := range procs {
+		if len(p.vm) == 0 {
+			continue
+		}
+		var v uint64
+		for _, field := range fields {
+			v += p.vm[field]
+		}
+		values = append(values, v)
+	}
+
+	var sum, min, max uint64
+	for i, v := range values {
+		sum += v
+		if i == 0 || v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	var result float64
+	switch mode {
+	case "sum":
+		result = float64(sum)
+	case "avg":
+		if len(values) > 0 {
+			result = float64(sum) / float64(len(values))
+		}
+	case "max":
+		result = float64(max)
+	case "min":
+		result = float64(min)
+	}
+	if memtype == "pmem" {
+		info, err := readMeminfo()
+		if err != nil {
+			return nil, err
+		}
+		return 100 * result / float64(info["MemTotal"]), nil
+	}
+	if mode == "avg" {
+		return result, nil
+	}
+	return uint64(result), nil
+}
+
+// procKey identifies a process across samples: the start time tells a
+// process from a later one with the same pid.
+type procKey struct {
+	pid   string
+	start uint64
+}
+
+// procTimes are the user and system CPU times of a process.
+type procTimes struct {
+	utime, stime uint64
+}
+
+// readProcTimes returns the CPU times of every process, for procSampler.
+func readProcTimes() (interface{}, error) {
+	pids, err := listPIDs()
+	if err != nil {
+		return nil, err
+	}
+	times := make(map[procKey]procTimes, len(pids))
+	for _, pid := range pids {
+		_, f, err := readStat(pid)
+		if err != nil {
+			continue
+		}
+		utime, _ := parseUint(f[11])
+		stime, _ := parseUint(f[12])
+		start, _ := parseUint(f[19])
+		times[procKey{pid, start}] = procTimes{utime, stime}
+	}
+	return times, nil
+}
+
+var procSampler = newSampler("process sampler", readProcTimes)
+
+// procCPUUtil is proc.cpu.util[<name>,<user>,<type>,<cmdline>,<mode>,
+// <zone>]: the percentage of one CPU the processes matching spent in user
+// or system time, or both (total, the default), over the last 1, 5 or 15
+// minutes (avg1 by default). Processes started within the window count
+// from their start, those gone by its end are not counted.
+func procCPUUtil(params []string) (interface{}, error) {
+	if err := maxParams(params, 6); err != nil {
+		return nil, err
+	}
+	typ, err := oneOf(params, 2, "type", "total", "user", "system")
+	if err != nil {
+		return nil, err
+	}
+	mode, err := oneOf(params, 4, "mode", "avg1", "avg5", "avg15")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkZone(params, 5); err != nil {
+		return nil, err
+	}
+	f, err := newProcFilter(params, 0, 1, 3)
+	if err != nil {
+		return nil, err
+	}
+	first, last, err := procSampler.window(averages[mode])
+	if err != nil {
+		return nil, err
+	}
+	procs, err := f.processes()
+	if err != nil {
+		return nil, err
+	}
+	from, to := first.v.(map[procKey]procTimes), last.v.(map[procKey]procTimes)
+	var ticks uint64
+	for _, p := range procs {
+		key := procKey{strconv.Itoa(p.pid), p.start}
+		b, ok := to[key]
+		if !ok { // started after the last sample
+			continue
+		}
+		a := from[key]
+		if typ != "system" && b.utime > a.utime {
+			ticks += b.utime - a.utime
+		}
+		if typ != "user" && b.stime > a.stime {
+			ticks += b.stime - a.stime
+		}
+	}
+	seconds := last.t.Sub(first.t).Seconds()
+	return 100 * float64(ticks) / clockTicks / seconds, nil
+}
+
+// procInfo is one process of proc.get, or one name in summary mode.
+type procInfo struct {
+	PID       int     `json:"pid,omitempty"`
+	PPID      int     `json:"ppid,omitempty"`
+	Name      string  `json:"name"`
+	Cmdline   string  `json:"cmdline,omitempty"`
+	User      string  `json:"user,omitempty"`
+	UID       *int    `json:"uid,omitempty"`
+	State     string  `json:"state,omitempty"`
+	Processes int     `json:"processes,omitempty"`
+	Threads   uint64  `json:"threads"`
+	VSize     uint64  `json:"vsize"`
+	RSS       uint64  `json:"rss"`
+	PMem      float64 `json:"pmem"`
+	CPUUser   float64 `json:"cputime_user"`
+	CPUSystem float64 `json:"cputime_system"`
+}
+
+// procGet is proc.get[<name>,<user>,<cmdline>,<mode>]: the processes
+// matching (mode process, the default) or their totals by name (summary),
+// with CPU times in seconds.
+func procGet(params []string) (interface{}, error) {
+	if err := maxParams(params, 4); err != nil {
+		return nil, err
+	}
+	mode, err := oneOf(params, 3, "mode", "process", "summary")
+	if err != nil {
+		return nil, err
+	}
+	f, err := newProcFilter(params, 0, 1, 2)
+	if err != nil {
+		return nil, err
+	}
+	procs, err := f.processes()
+	if err != nil {
+		return nil, err
+	}
+	info, err := readMeminfo()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string)
+	if users, err := readPasswd(); err == nil {
+		for name, uid := range users {
+			names[uid] = name
+		}
+	}
+
+	list := make([]procInfo, 0, len(procs))
+	byName := make(map[string]int)
+	for _, p := range procs {
+		pi := procInfo{
+			Name: p.name, Threads: p.threads,
+			VSize: p.vm["VmSize"], RSS: p.vm["VmRSS"],
+			PMem:      100 * float64(p.vm["VmRSS"]) / float64(info["MemTotal"]),
+			CPUUser:   float64(p.utime) / clockTicks,
+			CPUSystem: float64(p.stime) / clockTicks,
+		}
+		if mode == "process" {
+			uid := p.uid
+			pi.PID, pi.PPID, pi.Cmdline, pi.UID, pi.State = p.pid, p.ppid, p.cmdline, &uid, p.state
+			if pi.User = names[uid]; pi.User == "" {
+				pi.User = strconv.Itoa(uid)
+			}
+			list = append(list, pi)
+			continue
+		}
+		i, ok := byName[p.name]
+		if !ok {
+			i = len(list)
+			byName[p.name] = i
+			list = append(list, procInfo{Name: p.name})
+		}
+		s := &list[i]
+		s.Processes++
+		s.Threads += pi.Threads
+		s.VSize += pi.VSize
+		s.RSS += pi.RSS
+		s.PMem += pi.PMem
+		s.CPUUser += pi.CPUUser
+		s.CPUSystem += pi.CPUSystem
+	}
+	if mode == "summary" {
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	}
+	return list, nil
+}
@@ -0,0 +1,99 @@
+package collector
+
+import "testing"
+
+func TestReadProcess(t *testing.T) {
+	defer useTestdata()()
+	p, err := readProcess("200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.name != "python-long-nam" || p.argv0 != "python-long-name-tool" ||
+		p.cmdline != "/usr/bin/python-long-name-tool --serve" {
+		t.Errorf("got name %q, argv0 %q, cmdline %q", p.name, p.argv0, p.cmdline)
+	}
+	if p.pid != 200 || p.ppid != 1 || p.uid != 1000 || p.state != "trace" || p.threads != 4 {
+		t.Errorf("got pid %d, ppid %d, uid %d, state %s, threads %d", p.pid, p.ppid, p.uid, p.state, p.threads)
+	}
+	if p.utime != 50 || p.stime != 5 || p.start != 900 || p.vm["VmRSS"] != 200*1024 {
+		t.Errorf("got utime %d, stime %d, start %d, rss %d", p.utime, p.stime, p.start, p.vm["VmRSS"])
+	}
+	// a comm with parentheses, and no cmdline nor memory as a zombie
+	p, err = readProcess("201")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.name != "(sd-pam)" || p.state != "zomb" || p.cmdline != "" || len(p.vm) != 0 {
+		t.Errorf("got name %q, state %s, cmdline %q, vm %v", p.name, p.state, p.cmdline, p.vm)
+	}
+}
+
+func TestProcNum(t *testing.T) {
+	defer useTestdata()()
+	for key, want := range map[string]int{
+		"proc.num":                               7,
+		"proc.num[nginx]":                        3,
+		"proc.num[python-long-nam]":              1, // comm
+		"proc.num[python-long-name-tool]":        1, // the first argument, as comm is cut
+		"proc.num[,root]":                        3,
+		"proc.num[nginx,www-data]":               2,
+		"proc.num[nginx,33]":                     2,
+		"proc.num[,alice,zomb]":                  1,
+		"proc.num[,,all]":                        7,
+		"proc.num[,,run]":                        1,
+		"proc.num[,,sleep]":                      3,
+		"proc.num[,,disk]":                       1,
+		"proc.num[,,trace]":                      1,
+		"proc.num[nginx,,sleep]":                 1,
+		"proc.num[,,,worker]":                    2,
+		"proc.num[nginx,,,daemon off]":           1,
+		"proc.num[,,,^$]":                        2, // kthreadd and the zombie
+		"proc.num[,,,^/usr/(s?bin|lib)/]":        3,
+		"proc.num[nginx,root,,^/usr/sbin/nginx]": 1,
+		"proc.num[,,,,current]":                  7,
+		"proc.num[sshd]":                         0,
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %v, want %d", key, got, want)
+		}
+	}
+	collectErr(t, "proc.num[,nobody]")
+	collectErr(t, "proc.num[,,running]")
+	collectErr(t, "proc.num[,,,(]")
+	collectErr(t, "proc.num[,,,,local]")
+	collectErr(t, "proc.num[,,,,,]")
+}
+
+func TestProcMem(t *testing.T) {
+	defer useTestdata()()
+	for key, want := range map[string]uint64{
+		"proc.mem[nginx]":                        (2000 + 2200 + 2400) * 1024, // sum of vsize
+		"proc.mem[nginx,,max,,rss]":              80 * 1024,
+		"proc.mem[nginx,,min,,rss]":              40 * 1024,
+		"proc.mem[nginx,www-data,,,rss]":         (60 + 80) * 1024,
+		"proc.mem[nginx,,,worker,size]":          (500 + 132 + 800 + 700 + 132 + 800) * 1024,
+		"proc.mem[,root]":                        (10000 + 2000) * 1024, // not kthreadd
+		"proc.mem[,alice,,,rss]":                 200 * 1024,            // not the zombie
+		"proc.mem[python-long-name-tool,,,,exe]": 4 * 1024,
+		"proc.mem[,,,daemon,peak]":               2100 * 1024,
+		"proc.mem[sshd]":                         0,
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %v (%T), want %d", key, got, got, want)
+		}
+	}
+	for key, want := range map[string]float64{
+		"proc.mem[nginx,,avg,,rss]":  60 * 1024,
+		"proc.mem[,alice,avg]":       5000 * 1024, // the zombie is not counted
+		"proc.mem[nginx,,,,pmem]":    18,          // of a MemTotal of 1000 kB
+		"proc.mem[nginx,,max,,pmem]": 8,
+		"proc.mem[sshd,,avg]":        0,
+	} {
+		checkFloat(t, key, collect(t, key), want)
+	}
+	collectErr(t, "proc.mem[,,median]")
+	collectErr(t, "proc.mem[,,,,heap]")
+	collectErr(t, "proc.mem[,nobody]")
+	collectErr(t, "proc.mem[,,,(]")
+	collectErr(t, "proc.mem[,,,,,]")
+}
@@ -0,0 +1,245 @@
+/*******************************************************************************
+* FileName:  socket.go
+* Author: Victor
+* Date: 2019/09/06 10:30
+* Description: socket items: net.tcp.listen, net.udp.listen, net.tcp.port,
+*              net.tcp.socket.count and net.udp.socket.count
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("net.tcp.listen", func(params []string) (interface{}, error) {
+		return listening(params, "tcp", tcpStates["listen"])
+	})
+	Register("net.udp.listen", func(params []string) (interface{}, error) {
+		return listening(params, "udp", udpStates["unconn"])
+	})
+	RegisterTimed("net.tcp.port", tcpPort)
+	Register("net.tcp.socket.count", func(params []string) (interface{}, error) {
+		return socketCount(params, "tcp", tcpStates)
+	})
+	Register("net.udp.socket.count", func(params []string) (interface{}, error) {
+		return socketCount(params, "udp", udpStates)
+	})
+}
+
+// tcpStates and udpStates map the state names of the items onto the
+// states of /proc/net/tcp and udp. A bound, unconnected UDP socket is in
+// the TCP close state, shown as unconn.
+var (
+	tcpStates = map[string]int{
+		"established": 1, "syn_sent": 2, "syn_recv": 3, "fin_wait1": 4, "fin_wait2": 5, "time_wait": 6,
+		"close": 7, "close_wait": 8, "last_ack": 9, "listen": 10, "closing": 11,
+	}
+	udpStates = map[string]int{"established": 1, "unconn": 7}
+)
+
+// tcpPortMargin is kept of the item timeout by the connect test of
+// net.tcp.port, so that a port that does not answer gives 0 rather than
+// failing the item.
+const tcpPortMargin = 500 * time.Millisecond
+
+// socket is one line of /proc/net/tcp, tcp6, udp or udp6.
+type socket struct {
+	laddr, raddr net.IP
+	lport, rport int
+	state        int
+}
+
+// readSockets returns the IPv4 and IPv6 sockets of proto, tcp or udp.
+// A missing IPv6 table means IPv6 is disabled.
+func readSockets(proto string) ([]socket, error) {
+	var sockets []socket
+	for _, file := range []string{proto, proto + "6"} {
+		lines, err := readLines("proc", "net", file)
+		if err != nil {
+			if file != proto {
+				continue
+			}
+			return nil, err
+		}
+		for i, line := range lines {
+			f := strings.Fields(line)
+			if i == 0 || len(f) < 4 { // the header or a broken line
+				continue
+			}
+			laddr, lport, err1 := parseSocketAddr(f[1])
+			raddr, rport, err2 := parseSocketAddr(f[2])
+			state, err3 := strconv.ParseUint(f[3], 16, 8)
+			if err1 != nil || err2 != nil || err3 != nil {
+				return nil, fmt.Errorf("/proc/net/%s: invalid line %q", file, line)
+			}
+			sockets = append(sockets, socket{laddr, raddr, lport, rport, int(state)})
+		}
+	}
+	return sockets, nil
+}
+
+// parseSocketAddr parses an address such as 0100007F:0050. The kernel
+// prints the address in 32-bit words of host order, little endian on the
+// platforms the agent runs on, and the port in network order.
+func parseSocketAddr(s string) (net.IP, int, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	b, err := hex.DecodeString(s[:i])
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	ip := make(net.IP, len(b))
+	for j := 0; j < len(b); j += 4 {
+		binary.LittleEndian.PutUint32(ip[j:], binary.BigEndian.Uint32(b[j:]))
+	}
+	port, err := strconv.ParseUint(s[i+1:], 16, 16)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid address %q", s)
+	}
+	return ip, int(port), nil
+}
+
+// parsePort parses a port number or service name of proto; "" is any port,
+// returned as -1.
+func parsePort(proto, s string) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	port, err := net.LookupPort(proto, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// parseAddrFilter parses an IP address or a CIDR network; "" matches any
+// address.
+func parseAddrFilter(s string) (func(net.IP) bool, error) {
+	if s == "" {
+		return func(net.IP) bool { return true }, nil
+	}
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", s)
+		}
+		return n.Contains, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", s)
+	}
+	return ip.Equal, nil
+}
+
+// listening is net.tcp.listen and net.udp.listen[port]: 1 if a socket of
+// proto in state is bound to port, 0 otherwise.
+func listening(params []string, proto string, state int) (interface{}, error) {
+	if err := maxParams(params, 1); err != nil {
+		return nil, err
+	}
+	p := param(params, 0)
+	if p == "" {
+		return nil, fmt.Errorf("port not given")
+	}
+	port, err := parsePort(proto, p)
+	if err != nil {
+		return nil, err
+	}
+	sockets, err := readSockets(proto)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sockets {
+		if s.state == state && s.lport == port {
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// tcpPort is net.tcp.port[<ip>,port]: 1 if a TCP connection to ip
+// (127.0.0.1 by default) and port can be made, 0 otherwise.
+func tcpPort(params []string, timeout time.Duration) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	host := param(params, 0)
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	p := param(params, 1)
+	if p == "" {
+		return nil, fmt.Errorf("port not given")
+	}
+	port, err := parsePort("tcp", p)
+	if err != nil {
+		return nil, err
+	}
+	dial := timeout - tcpPortMargin
+	if dial < timeout/2 {
+		dial = timeout / 2
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), dial)
+	if err != nil {
+		return 0, nil
+	}
+	conn.Close()
+	return 1, nil
+}
+
+// socketCount is net.tcp.socket.count and net.udp.socket.count[<laddr>,
+// <lport>,<raddr>,<rport>,<state>]: the number of sockets of proto
+// matching every filter given. Addresses may be networks, ports service
+// names.
+func socketCount(params []string, proto string, states map[string]int) (interface{}, error) {
+	if err := maxParams(params, 5); err != nil {
+		return nil, err
+	}
+	laddr, err := parseAddrFilter(param(params, 0))
+	if err != nil {
+		return nil, err
+	}
+	lport, err := parsePort(proto, param(params, 1))
+	if err != nil {
+		return nil, err
+	}
+	raddr, err := parseAddrFilter(param(params, 2))
+	if err != nil {
+		return nil, err
+	}
+	rport, err := parsePort(proto, param(params, 3))
+	if err != nil {
+		return nil, err
+	}
+	state := -1
+	if p := param(params, 4); p != "" {
+		st, ok := states[p]
+		if !ok {
+			return nil, fmt.Errorf("invalid state %q", p)
+		}
+		state = st
+	}
+	sockets, err := readSockets(proto)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for _, s := range sockets {
+		if (state < 0 || s.state == state) && (lport < 0 || s.lport == lport) &&
+			(rport < 0 || s.rport == rport) && laddr(s.laddr) && raddr(s.raddr) {
+			n++
+		}
+	}
+	return n, nil
+}
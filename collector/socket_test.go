@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// listenTCP listens on a free port of 127.0.0.1 and returns the port.
+func listenTCP(t *testing.T) (net.Listener, int) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, l.Addr().(*net.TCPAddr).Port
+}
+
+// closedPort returns a port of 127.0.0.1 nothing listens on.
+func closedPort(t *testing.T) int {
+	t.Helper()
+	l, port := listenTCP(t)
+	l.Close()
+	return port
+}
+
+func TestParseSocketAddr(t *testing.T) {
+	for s, want := range map[string]string{
+		"0100007F:0050":                         "127.0.0.1:80",
+		"00000000:1F90":                         "0.0.0.0:8080",
+		"00000000000000000000000001000000:0016": "[::1]:22",
+		"0000000000000000FFFF00000100007F:01BB": "127.0.0.1:443", // v4 mapped
+	} {
+		ip, port, err := parseSocketAddr(s)
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if got := net.JoinHostPort(ip.String(), fmt.Sprint(port)); got != want {
+			t.Errorf("%s = %s, want %s", s, got, want)
+		}
+	}
+	for _, s := range []string{"0100007F", "0100007:0050", "0100007F:", "zz00007F:0050"} {
+		if _, _, err := parseSocketAddr(s); err == nil {
+			t.Errorf("%s parsed", s)
+		}
+	}
+}
+
+func TestTCPListenAndPort(t *testing.T) {
+	l, port := listenTCP(t)
+	defer l.Close()
+	closed := closedPort(t)
+
+	for key, want := range map[string]int{
+		fmt.Sprintf("net.tcp.listen[%d]", port):           1,
+		fmt.Sprintf("net.tcp.listen[%d]", closed):         0,
+		fmt.Sprintf("net.udp.listen[%d]", port):           0,
+		fmt.Sprintf("net.tcp.port[,%d]", port):            1,
+		fmt.Sprintf("net.tcp.port[127.0.0.1,%d]", port):   1,
+		fmt.Sprintf("net.tcp.port[,%d]", closed):          0,
+		fmt.Sprintf("net.tcp.port[127.0.0.1,%d]", closed): 0,
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %v, want %d", key, got, want)
+		}
+	}
+	collectErr(t, "net.tcp.listen")
+	collectErr(t, "net.tcp.port[127.0.0.1]")
+	collectErr(t, "net.tcp.port[,nosuchservice]")
+}
+
+// A port that does not answer gives 0 within the item timeout rather than
+// timing the item out.
+func TestTCPPortNoAnswer(t *testing.T) {
+	start := time.Now()
+	v, err := Collect("net.tcp.port[10.255.255.1,80]", time.Second) // not routed
+	if err != nil {
+		t.Fatalf("got %v, want 0", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %v", d)
+	}
+	if v == 1 {
+		t.Skip("10.255.255.1 answers on this network")
+	}
+	if v != 0 {
+		t.Errorf("got %v, want 0", v)
+	}
+}
+
+func TestUDPListen(t *testing.T) {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	key := fmt.Sprintf("net.udp.listen[%d]", c.LocalAddr().(*net.UDPAddr).Port)
+	if got := collect(t, key); got != 1 {
+		t.Errorf("%s = %v, want 1", key, got)
+	}
+}
+
+func TestSocketCount(t *testing.T) {
+	l, port := listenTCP(t)
+	defer l.Close()
+	client, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	u, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.Close()
+	uport := u.LocalAddr().(*net.UDPAddr).Port
+	uc, err := net.Dial("udp", u.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+
+	for key, want := range map[string]int{
+		// the listener and the connection it accepted
+		fmt.Sprintf("net.tcp.socket.count[,%d]", port):                         2,
+		fmt.Sprintf("net.tcp.socket.count[,%d,,,listen]", port):                1,
+		fmt.Sprintf("net.tcp.socket.count[,%d,,,established]", port):           1,
+		fmt.Sprintf("net.tcp.socket.count[127.0.0.0/8,%d]", port):              2,
+		fmt.Sprintf("net.tcp.socket.count[10.0.0.0/8,%d]", port):               0,
+		fmt.Sprintf("net.tcp.socket.count[::1,%d]", port):                      0,
+		fmt.Sprintf("net.tcp.socket.count[,,127.0.0.1,%d]", port):              1, // the client
+		fmt.Sprintf("net.tcp.socket.count[,,,%d,established]", port):           1,
+		fmt.Sprintf("net.tcp.socket.count[127.0.0.1,,127.0.0.1,%d]", port):     1,
+		fmt.Sprintf("net.tcp.socket.count[,%d,,,time_wait]", port):             0,
+		fmt.Sprintf("net.udp.socket.count[,%d]", uport):                        1,
+		fmt.Sprintf("net.udp.socket.count[,%d,,,unconn]", uport):               1,
+		fmt.Sprintf("net.udp.socket.count[,,,%d,established]", uport):          1,
+		fmt.Sprintf("net.udp.socket.count[127.0.0.1/32,,127.0.0.1,%d]", uport): 1,
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %v, want %d", key, got, want)
+		}
+	}
+	collectErr(t, "net.tcp.socket.count[,,,,bound]")
+	collectErr(t, "net.udp.socket.count[,,,,listen]")
+	collectErr(t, "net.tcp.socket.count[300.0.0.1]")
+	collectErr(t, "net.tcp.socket.count[10.0.0.0/33]")
+	collectErr(t, "net.tcp.socket.count[,,,,,]")
+}
@@ -0,0 +1,348 @@
+/*******************************************************************************
+* FileName:  software.go
+* Author: Victor
+* Date: 2019/09/12 10:05
+* Description: software items: system.sw.packages, system.sw.packages.get
+*              and system.sw.os
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterTimed("system.sw.packages", swPackages)
+	RegisterTimed("system.sw.packages.get", swPackagesGet)
+	Register("system.sw.os", swOS)
+}
+
+var rpmCommand, rpmFile string
+
+// SetRPMSource sets how system.sw.packages lists the rpm packages: by
+// reading file if it is not empty, else by running command with the host
+// root in $HOSTROOT.
+func SetRPMSource(command, file string) {
+	mu.Lock()
+	defer mu.Unlock()
+	rpmCommand, rpmFile = command, file
+}
+
+// swPackage is one installed package, as in system.sw.packages.get.
+// InstallTime is a Unix time, 0 when the manager does not record it.
+type swPackage struct {
+	Name        string `json:"name"`
+	Manager     string `json:"manager"`
+	Version     string `json:"version"`
+	Arch        string `json:"arch"`
+	Size        uint64 `json:"size"`
+	InstallTime int64  `json:"installtime"`
+}
+
+// swManagers are the package managers by name, in output order. A
+// manager not installed on the host lists nothing. The timeout is that of
+// the item, for the managers running a command.
+var swManagers = []struct {
+	name string
+	list func(timeout time.Duration) ([]swPackage, error)
+}{
+	{"dpkg", dpkgPackages},
+	{"rpm", rpmPackages},
+	{"pacman", pacmanPackages},
+}
+
+// dpkgPackages reads the installed packages of /var/lib/dpkg/status. The
+// install time is that of the file list of the package.
+func dpkgPackages(time.Duration) ([]swPackage, error) {
+	lines, err := readLines("var", "lib", "dpkg", "status")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []swPackage
+	fields := make(map[string]string)
+	add := func() {
+		defer func() { fields = make(map[string]string) }()
+		status := strings.Fields(fields["Status"])
+		if fields["Package"] == "" || len(status) != 3 || status[2] != "installed" {
+			return
+		}
+		p := swPackage{Name: fields["Package"], Manager: "dpkg", Version: fields["Version"],
+			Arch: fields["Architecture"]}
+		if kb, err := parseUint(fields["Installed-Size"]); err == nil {
+			p.Size = kb * 1024
+		}
+		for _, name := range []string{p.Name + ":" + p.Arch, p.Name} {
+			if fi, err := os.Stat(hostPath("var", "lib", "dpkg", "info", name+".list")); err == nil {
+				p.InstallTime = fi.ModTime().Unix()
+				break
+			}
+		}
+		pkgs = append(pkgs, p)
+	}
+	for _, line := range lines {
+		if line == "" {
+			add()
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' { // continuation of a long field
+			continue
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			fields[line[:i]] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	add()
+	return pkgs, nil
+}
+
+// rpmPackages reads the list of agent.rpmfile or runs agent.rpmcommand,
+// killing it when the item times out. The command only runs on hosts with
+// an rpm database.
+func rpmPackages(timeout time.Duration) ([]swPackage, error) {
+	mu.RLock()
+	command, file, root := rpmCommand, rpmFile, hostRoot
+	mu.RUnlock()
+	var out []byte
+	switch {
+	case file != "":
+		var err error
+		if out, err = ioutil.ReadFile(file); err != nil {
+			return nil, err
+		}
+	case command != "":
+		if _, err := os.Stat(hostPath("var", "lib", "rpm")); err != nil {
+			return nil, nil
+		}
+		var stdout, stderr bytes.Buffer
+		err := runShell(command, []string{"HOSTROOT=" + root}, &stdout, &stderr, timeout)
+		if err == errTimedOut {
+			return nil, fmt.Errorf("rpm command: timed out after %v", timeout)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rpm command: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		out = stdout.Bytes()
+	default:
+		return nil, nil
+	}
+	var pkgs []swPackage
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Split(strings.TrimRight(line, "\r"), "\t")
+		if len(f) < 2 || f[0] == "" {
+			continue
+		}
+		p := swPackage{Name: f[0], Manager: "rpm", Version: f[1]}
+		if len(f) > 2 && f[2] != "(none)" {
+			p.Arch = f[2]
+		}
+		if len(f) > 3 {
+			p.Size, _ = parseUint(f[3])
+		}
+		if len(f) > 4 {
+			p.InstallTime, _ = strconv.ParseInt(f[4], 10, 64)
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
+
+// pacmanPackages reads the desc files of the pacman local database.
+func pacmanPackages(time.Duration) ([]swPackage, error) {
+	dirs, err := listDir("var", "lib", "pacman", "local")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []swPackage
+	for _, dir := range dirs {
+		lines, err := readLines("var", "lib", "pacman", "local", dir, "desc")
+		if err != nil {
+			continue // ALPM_DB_VERSION is a file
+		}
+		p := swPackage{Manager: "pacman"}
+		for i := 0; i+1 < len(lines); i++ {
+			value := lines[i+1]
+			switch lines[i] {
+			case "%NAME%":
+				p.Name = value
+			case "%VERSION%":
+				p.Version = value
+			case "%ARCH%":
+				p.Arch = value
+			case "%SIZE%":
+				p.Size, _ = parseUint(value)
+			case "%INSTALLDATE%":
+				p.InstallTime, _ = strconv.ParseInt(value, 10, 64)
+			default:
+				continue
+			}
+			i++
+		}
+		if p.Name != "" {
+			pkgs = append(pkgs, p)
+		}
+	}
+	return pkgs, nil
+}
+
+// listPackages returns the packages of manager (all by default) whose name
+// matches the regular expression, sorted by manager and name.
+func listPackages(params []string, timeout time.Duration) ([]swPackage, error) {
+	var re *regexp.Regexp
+	if p := param(params, 0); p != "" && p != "all" {
+		var err error
+		if re, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("invalid package regular expression: %v", err)
+		}
+	}
+	manager := param(params, 1)
+	if manager == "" {
+		manager = "all"
+	}
+	var pkgs []swPackage
+	found := false
+	for _, m := range swManagers {
+		if manager != "all" && manager != m.name {
+			continue
+		}
+		found = true
+		list, err := m.list(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", m.name, err)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		for _, p := range list {
+			if re == nil || re.MatchString(p.Name) {
+				pkgs = append(pkgs, p)
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("invalid manager %q, use all, dpkg, rpm, pacman", manager)
+	}
+	return pkgs, nil
+}
+
+// swPackages is system.sw.packages[<regexp>,<manager>,<format>]: the names
+// of the installed packages, comma separated, on one line per manager
+// starting with its name (full, the default) or all on one line (short).
+func swPackages(params []string, timeout time.Duration) (interface{}, error) {
+	if err := maxParams(params, 3); err != nil {
+		return nil, err
+	}
+	format, err := oneOf(params, 2, "format", "full", "short")
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := listPackages(params, timeout)
+	if err != nil {
+		return nil, err
+	}
+	var names, lines []string
+	for i, p := range pkgs {
+		names = append(names, p.Name)
+		if format == "full" && (i+1 == len(pkgs) || pkgs[i+1].Manager != p.Manager) {
+			lines = append(lines, "["+p.Manager+"] "+strings.Join(names, ", "))
+			names = nil
+		}
+	}
+	if format == "short" {
+		return strings.Join(names, ", "), nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// swPackagesGet is system.sw.packages.get[<regexp>,<manager>]: the
+// installed packages with their versions.
+func swPackagesGet(params []string, timeout time.Duration) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	pkgs, err := listPackages(params, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if pkgs == nil {
+		pkgs = []swPackage{}
+	}
+	return pkgs, nil
+}
+
+// readOSRelease returns the variables of /etc/os-release, or of
+// /usr/lib/os-release it falls back to, unquoted.
+func readOSRelease() (map[string]string, error) {
+	lines, err := readLines("etc", "os-release")
+	if os.IsNotExist(err) {
+		lines, err = readLines("usr", "lib", "os-release")
+	}
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		i := strings.IndexByte(line, '=')
+		if i <= 0 || line[0] == '#' {
+			continue
+		}
+		value := line[i+1:]
+		if n := len(value); n >= 2 && (value[0] == '"' || value[0] == '\'') && value[n-1] == value[0] {
+			quote := value[0]
+			value = value[1 : n-1]
+			if quote == '"' {
+				value = strings.NewReplacer(`\"`, `"`, `\\`, `\`, "\\$", "$", "\\`", "`").Replace(value)
+			}
+		}
+		vars[line[:i]] = value
+	}
+	return vars, nil
+}
+
+// swOS is system.sw.os[<info>]: the operating system as its pretty name
+// (full, the default), as id and version (short, e.g. "debian 10") or its
+// name alone.
+func swOS(params []string) (interface{}, error) {
+	if err := maxParams(params, 1); err != nil {
+		return nil, err
+	}
+	info, err := oneOf(params, 0, "info", "full", "short", "name")
+	if err != nil {
+		return nil, err
+	}
+	vars, err := readOSRelease()
+	if err != nil {
+		return nil, err
+	}
+	name := vars["NAME"]
+	if name == "" {
+		name = "Linux" // the default of os-release(5)
+	}
+	switch info {
+	case "short":
+		id := vars["ID"]
+		if id == "" {
+			id = "linux"
+		}
+		return strings.TrimSpace(id + " " + vars["VERSION_ID"]), nil
+	case "name":
+		return name, nil
+	}
+	if pretty := vars["PRETTY_NAME"]; pretty != "" {
+		return pretty, nil
+	}
+	return strings.TrimSpace(name + " " + vars["VERSION"]), nil
+}
@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// useRPMCommand makes system.sw.packages run command until the returned
+// function is called.
+func useRPMCommand(command string) func() {
+	SetRPMSource(command, "")
+	return func() { SetRPMSource("", "") }
+}
+
+func TestRPMCommand(t *testing.T) {
+	defer useTestdata()()
+	// the command sees the host root it is to pass to rpm --root
+	defer useRPMCommand(`test "$HOSTROOT" = testdata || exit 1
+printf 'bash\t5.0-4.el8\tx86_64\t7000000\t1567400000\n'
+printf 'gpg-pubkey\t8483c65d-5ccc5b19\t(none)\t0\t1567300000\n'`)()
+
+	got := collect(t, "system.sw.packages[,rpm]")
+	if want := "[rpm] bash, gpg-pubkey"; got != want {
+		t.Errorf("system.sw.packages[,rpm] = %q, want %q", got, want)
+	}
+	pkgs := collect(t, "system.sw.packages.get[^bash$,rpm]").([]swPackage)
+	want := swPackage{"bash", "rpm", "5.0-4.el8", "x86_64", 7000000, 1567400000}
+	if len(pkgs) != 1 || pkgs[0] != want {
+		t.Errorf("system.sw.packages.get[^bash$,rpm] = %+v, want %+v", pkgs, want)
+	}
+}
+
+func TestRPMCommandTimeout(t *testing.T) {
+	defer useTestdata()()
+	defer useRPMCommand("sleep 10")()
+	start := time.Now()
+	_, err := rpmPackages(200 * time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("the command was not killed at the timeout, took %v", d)
+	}
+}
+
+func TestRPMCommandNoDatabase(t *testing.T) {
+	defer useRPMCommand("exit 1")()
+	SetHostRoot(t.Name()) // no var/lib/rpm below
+	defer SetHostRoot("/")
+	if pkgs, err := rpmPackages(time.Second); pkgs != nil || err != nil {
+		t.Errorf("got %v, %v without an rpm database", pkgs, err)
+	}
+}
@@ -0,0 +1,203 @@
+/*******************************************************************************
+* FileName:  system.go
+* Author: Victor
+* Date: 2019/09/10 09:30
+* Description: system items: system.uptime, boottime, hostname, uname,
+*              users.num and localtime
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func init() {
+	Register("system.uptime", systemUptime)
+	Register("system.boottime", func(params []string) (interface{}, error) {
+		if err := maxParams(params, 0); err != nil {
+			return nil, err
+		}
+		return statCounter("btime")
+	})
+	Register("system.hostname", systemHostname)
+	Register("system.uname", systemUname)
+	Register("system.users.num", usersNum)
+	Register("system.localtime", localtime)
+}
+
+// systemUptime is system.uptime, in seconds.
+func systemUptime(params []string) (interface{}, error) {
+	if err := maxParams(params, 0); err != nil {
+		return nil, err
+	}
+	s, err := readString("proc", "uptime")
+	if err != nil {
+		return nil, err
+	}
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return nil, fmt.Errorf("/proc/uptime: unexpected content %q", s)
+	}
+	uptime, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("/proc/uptime: %v", err)
+	}
+	return uint64(uptime), nil
+}
+
+// hostname returns the host name of the watched host.
+func hostname() (string, error) {
+	if name, err := readString("proc", "sys", "kernel", "hostname"); err == nil && name != "" {
+		return name, nil
+	}
+	return os.Hostname()
+}
+
+// fqdn returns the first fully qualified name the addresses of host
+// resolve back to, or host itself.
+func fqdn(host string) string {
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return host
+	}
+	for _, addr := range addrs {
+		names, err := net.LookupAddr(addr)
+		if err != nil {
+			continue
+		}
+		for _, name := range names {
+			if name = strings.TrimSuffix(name, "."); strings.Contains(name, ".") {
+				return name
+			}
+		}
+	}
+	return host
+}
+
+// systemHostname is system.hostname[<type>,<transform>]: the host name
+// (host, the default), the fully qualified one (fqdn) or the NetBIOS one,
+// which is the first label of the host name upper cased and cut to 15
+// characters. The lower transform lower cases the result.
+func systemHostname(params []string) (interface{}, error) {
+	if err := maxParams(params, 2); err != nil {
+		return nil, err
+	}
+	typ, err := oneOf(params, 0, "type", "host", "fqdn", "netbios")
+	if err != nil {
+		return nil, err
+	}
+	transform, err := oneOf(params, 1, "transform", "none", "lower")
+	if err != nil {
+		return nil, err
+	}
+	name, err := hostname()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "fqdn":
+		name = fqdn(name)
+	case "netbios":
+		if i := strings.IndexByte(name, '.'); i >= 0 {
+			name = name[:i]
+		}
+		if len(name) > 15 {
+			name = name[:15]
+		}
+		name = strings.ToUpper(name)
+	}
+	if transform == "lower" {
+		name = strings.ToLower(name)
+	}
+	return name, nil
+}
+
+// utsString returns a field of syscall.Utsname, whose arrays are of int8
+// or uint8 depending on the platform.
+func utsString(p unsafe.Pointer) string {
+	b := (*[65]byte)(p)[:]
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// systemUname is system.uname: the kernel name, host name, release,
+// version and machine, as uname -snrvm prints them.
+func systemUname(params []string) (interface{}, error) {
+	if err := maxParams(params, 0); err != nil {
+		return nil, err
+	}
+	var u syscall.Utsname
+	if err := syscall.Uname(&u); err != nil {
+		return nil, err
+	}
+	return strings.Join([]string{
+		utsString(unsafe.Pointer(&u.Sysname)),
+		utsString(unsafe.Pointer(&u.Nodename)),
+		utsString(unsafe.Pointer(&u.Release)),
+		utsString(unsafe.Pointer(&u.Version)),
+		utsString(unsafe.Pointer(&u.Machine)),
+	}, " "), nil
+}
+
+// utmp record layout of glibc on Linux: 384 bytes starting with the
+// 16-bit record type, the user name at offset 44.
+const (
+	utmpSize        = 384
+	utmpUserOffset  = 44
+	utmpUserProcess = 7
+)
+
+// usersNum is system.users.num: the number of login sessions recorded in
+// utmp. A host without utmp has none.
+func usersNum(params []string) (interface{}, error) {
+	if err := maxParams(params, 0); err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(hostPath("run", "utmp"))
+	if os.IsNotExist(err) { // /var/run is a link to /run but for old hosts
+		b, err = ioutil.ReadFile(hostPath("var", "run", "utmp"))
+	}
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for ; len(b) >= utmpSize; b = b[utmpSize:] {
+		if binary.LittleEndian.Uint16(b) == utmpUserProcess && b[utmpUserOffset] != 0 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// localtime is system.localtime[<type>]: the Unix time (utc, the default)
+// or the local time with its offset (local), as 2019-09-10,09:30:00.000,+08:00.
+func localtime(params []string) (interface{}, error) {
+	if err := maxParams(params, 1); err != nil {
+		return nil, err
+	}
+	typ, err := oneOf(params, 0, "type", "utc", "local")
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if typ == "local" {
+		return now.Format("2006-01-02,15:04:05.000,-07:00"), nil
+	}
+	return now.Unix(), nil
+}
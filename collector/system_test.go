@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestUptime(t *testing.T) {
+	defer useTestdata()()
+	if got := collect(t, "system.uptime"); got != uint64(12345) {
+		t.Errorf("system.uptime = %v (%T), want 12345", got, got)
+	}
+	if got := collect(t, "system.boottime"); got != uint64(1567400000) {
+		t.Errorf("system.boottime = %v (%T), want 1567400000", got, got)
+	}
+	collectErr(t, "system.uptime[s]")
+	collectErr(t, "system.boottime[s]")
+}
+
+func TestHostname(t *testing.T) {
+	defer useTestdata()()
+	for key, want := range map[string]string{
+		"system.hostname":                "web01.example.com",
+		"system.hostname[host]":          "web01.example.com",
+		"system.hostname[netbios]":       "WEB01",
+		"system.hostname[netbios,lower]": "web01",
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %v, want %s", key, got, want)
+		}
+	}
+	collectErr(t, "system.hostname[nis]")
+	collectErr(t, "system.hostname[,upper]")
+}
+
+// The utmp fixture holds the boot time, run level and a getty waiting for
+// a login, the sessions of alice and root, the ended session of bob and a
+// user process without a user name.
+func TestUsersNum(t *testing.T) {
+	defer useTestdata()()
+	if got := collect(t, "system.users.num"); got != 2 {
+		t.Errorf("system.users.num = %v, want 2", got)
+	}
+	collectErr(t, "system.users.num[all]")
+
+	// an old host keeps it in /var/run, and one without utmp has no users
+	root, err := ioutil.TempDir("", "utmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	SetHostRoot(root)
+	if got := collect(t, "system.users.num"); got != 0 {
+		t.Errorf("system.users.num = %v without utmp, want 0", got)
+	}
+	b, err := ioutil.ReadFile("testdata/run/utmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "var", "run"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	b = b[:len(b)-utmpSize-10] // the sessions of alice and bob, and part of root's
+	if err := ioutil.WriteFile(filepath.Join(root, "var", "run", "utmp"), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := collect(t, "system.users.num"); got != 1 {
+		t.Errorf("system.users.num = %v from /var/run/utmp, want 1", got)
+	}
+}
+
+func TestLocaltime(t *testing.T) {
+	if _, ok := collect(t, "system.localtime").(int64); !ok {
+		t.Errorf("system.localtime is not a Unix time")
+	}
+	local := collect(t, "system.localtime[local]").(string)
+	if !regexp.MustCompile(`^\d{4}-\d\d-\d\d,\d\d:\d\d:\d\d\.\d{3},[+-]\d\d:\d\d$`).MatchString(local) {
+		t.Errorf("system.localtime[local] = %s", local)
+	}
+	collectErr(t, "system.localtime[gmt]")
+}
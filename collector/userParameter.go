@@ -0,0 +1,144 @@
+/*******************************************************************************
+* FileName:  userParameter.go
+* Author: Victor
+* Date: 2019/08/27 14:45
+* Description: zabbix_agentd style user parameters
+* Project: zabbix_agent
+*******************************************************************************/
+package collector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// UserParameter is one "key,command" entry. A key ending in [*] is
+// flexible: $1..$9 in the command are replaced by the key parameters.
+type UserParameter struct {
+	Name     string
+	Flexible bool
+	Command  string
+}
+
+// UserParameters maps item names to their user parameter.
+type UserParameters map[string]*UserParameter
+
+// ParseUserParameters parses every "key,command" entry, failing on the
+// first malformed one or on a key clashing with a built-in item.
+func ParseUserParameters(list []string) (UserParameters, error) {
+	ups := make(UserParameters)
+	for _, s := range list {
+		i := strings.Index(s, ",")
+		if i <= 0 {
+			return nil, fmt.Errorf("user parameter %q: expected key,command", s)
+		}
+		up := &UserParameter{Name: strings.TrimSpace(s[:i]), Command: strings.TrimSpace(s[i+1:])}
+		if strings.HasSuffix(up.Name, "[*]") {
+			up.Name = strings.TrimSuffix(up.Name, "[*]")
+			up.Flexible = true
+		}
+		if !validName(up.Name) {
+			return nil, fmt.Errorf("user parameter %q: invalid key", s)
+		}
+		if up.Command == "" {
+			return nil, fmt.Errorf("user parameter %q: empty command", s)
+		}
+		if Registered(up.Name) {
+			return nil, fmt.Errorf("user parameter %q: %s is a built-in item", s, up.Name)
+		}
+		if _, ok := ups[up.Name]; ok {
+			return nil, fmt.Errorf("user parameter %q: %s is defined more than once", s, up.Name)
+		}
+		ups[up.Name] = up
+	}
+	return ups, nil
+}
+
+// SetUserParameters replaces the active user parameters in one step.
+func SetUserParameters(ups UserParameters) {
+	mu.Lock()
+	defer mu.Unlock()
+	userParams = ups
+}
+
+// command returns the shell command for the given key parameters.
+func (up *UserParameter) command(params []string) (string, error) {
+	if !up.Flexible {
+		if len(params) > 0 {
+			return "", fmt.Errorf("%s does not accept parameters", up.Name)
+		}
+		return up.Command, nil
+	}
+	var b strings.Builder
+	cmd := up.Command
+	for i := 0; i < len(cmd); i++ {
+		if cmd[i] != '$' || i+1 == len(cmd) || cmd[i+1] < '0' || cmd[i+1] > '9' {
+			b.WriteByte(cmd[i])
+			continue
+		}
+		i++
+		n := int(cmd[i] - '0')
+		switch {
+		case n == 0:
+			b.WriteString(up.Command)
+		case n <= len(params):
+			if strings.ContainsAny(params[n-1], "\\'\"`*?[]{}~$!&;()<>|#@\n") {
+				return "", fmt.Errorf("%s: parameter %d contains special characters", up.Name, n)
+			}
+			b.WriteString(params[n-1])
+		}
+	}
+	return b.String(), nil
+}
+
+func (up *UserParameter) run(params []string, timeout time.Duration) (interface{}, error) {
+	cmdline, err := up.command(params)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	err = runShell(cmdline, nil, &out, &out, timeout)
+	if err == errTimedOut {
+		return nil, fmt.Errorf("%s: timed out after %v", up.Name, timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %s", up.Name, err, strings.TrimSpace(out.String()))
+	}
+	return strings.TrimRight(out.String(), " \r\n\t"), nil
+}
+
+// errTimedOut is returned by runShell for a command killed at its timeout.
+var errTimedOut = errors.New("timed out")
+
+// runShell runs command with sh, env added to the environment of the
+// agent. The shell and what it starts run in a process group of their own,
+// killed as a whole at the timeout: killing the shell alone would leave a
+// pipeline running, holding the output open.
+func runShell(command string, env []string, stdout, stderr io.Writer, timeout time.Duration) error {
+	cmd := exec.Command("sh", "-c", command)
+	if env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return errTimedOut
+	}
+}
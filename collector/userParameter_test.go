@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// useUserParameters makes list the active user parameters until the
+// returned function is called.
+func useUserParameters(t *testing.T, list ...string) func() {
+	t.Helper()
+	ups, err := ParseUserParameters(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetUserParameters(ups)
+	return func() { SetUserParameters(nil) }
+}
+
+func TestUserParameter(t *testing.T) {
+	defer useUserParameters(t,
+		"test.echo,echo ' hello '",
+		"test.args[*],echo $2-$1",
+		"test.fail,echo broken >&2; exit 3",
+	)()
+	for key, want := range map[string]string{
+		"test.echo":          " hello",
+		"test.args[a,b]":     "b-a",
+		"test.args[a]":       "-a",
+		"test.args[1,2,3,4]": "2-1",
+	} {
+		if got := collect(t, key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if err := collectErr(t, "test.fail"); !strings.Contains(err.Error(), "exit status 3: broken") {
+		t.Errorf("test.fail: %v", err)
+	}
+	collectErr(t, "test.echo[x]")
+	collectErr(t, "test.args[$(id)]")
+}
+
+func TestParseUserParametersInvalid(t *testing.T) {
+	for _, list := range [][]string{
+		{"test.nocommand"},
+		{",echo"},
+		{"test.empty, "},
+		{"bad key,echo"},
+		{"system.uptime,echo 1"},
+		{"test.twice,echo 1", "test.twice[*],echo 2"},
+	} {
+		if _, err := ParseUserParameters(list); err == nil {
+			t.Errorf("%q parsed", list)
+		}
+	}
+}
+
+// A pipeline is killed as a whole at the timeout: its last command does
+// not keep the item waiting for the output to close.
+func TestUserParameterTimeout(t *testing.T) {
+	up := &UserParameter{Name: "test.slow", Command: "sleep 5 | cat"}
+	start := time.Now()
+	_, err := up.run(nil, 300*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("the pipeline was not killed at the timeout, took %v", d)
+	}
+}
@@ -0,0 +1,160 @@
+/*******************************************************************************
+* FileName:  agentd.go
+* Author: Victor
+* Date: 2019/08/26 14:30
+* Description: reader for the native zabbix_agentd.conf format
+* Project: zabbix_agent
+*******************************************************************************/
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// IsAgentdConf reports whether path is read in the zabbix_agentd.conf
+// format rather than as TOML.
+func IsAgentdConf(path string) bool {
+	return filepath.Ext(path) == ".conf"
+}
+
+// agentdKeys maps the zabbix_agentd.conf parameters that translate one to
+// one onto a config key. ServerActive, DebugLevel and LogType need
+// converting and are handled by parseAgentd itself; anything else is
+// unsupported.
+var agentdKeys = map[string]string{
+	"Hostname":      "agent.hostname",
+	"UserParameter": "agent.userparameter",
+	"Timeout":       "agent.timeout",
+	"LogFile":       "agent.logfile",
+	"LogFileSize":   "agent.logfilesize",
+	"BufferSend":    "agent.buffersend",
+	"BufferSize":    "agent.buffersize",
+	"Include":       "include",
+}
+
+// passiveParams are the zabbix_agentd.conf parameters of passive checks,
+// which the agent does not serve yet.
+var passiveParams = map[string]bool{"Server": true, "ListenPort": true, "ListenIP": true, "StartAgents": true}
+
+// debugLevels maps DebugLevel=0..5 onto agent.loglevel.
+var debugLevels = []string{"error", "error", "error", "warn", "debug", "trace"}
+
+// parseAgentd reads the zabbix_agentd.conf style file at path into frag and
+// returns the keys it defines. Unsupported parameters only produce a
+// warning so an existing file can be used unchanged. Such a file defines
+// no items: the agent does not fetch active checks from the server, so
+// they come from a TOML file, e.g. one included with Include=.
+func (c *Config) parseAgentd(path string, frag *Config, e *ValidationError) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	defined := make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		where := fmt.Sprintf("%s:%d", path, lineno)
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			e.add("%s: expected Parameter=value", where)
+			continue
+		}
+		param := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+
+		var key string
+		var values []string
+		switch param {
+		case "ServerActive":
+			host, port, err := splitServerActive(value)
+			if err != nil {
+				e.add("%s: ServerActive: %v", where, err)
+				continue
+			}
+			if strings.Contains(value, ",") {
+				c.warn("%s: ServerActive: only the first server %s is used", where, host)
+			}
+			c.setAgentd(frag, defined, "server.ip", host, where, e)
+			if port != "" {
+				c.setAgentd(frag, defined, "server.port", port, where, e)
+			}
+			continue
+		case "DebugLevel":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n >= len(debugLevels) {
+				e.add("%s: DebugLevel: %q is not in range 0-5", where, value)
+				continue
+			}
+			key, values = "agent.loglevel", []string{debugLevels[n]}
+		case "LogType":
+			// zabbix_agentd calls syslog "system"
+			if value == "system" {
+				value = "syslog"
+			}
+			key, values = "agent.logtype", []string{value}
+		default:
+			if passiveParams[param] {
+				c.warn("%s: %s ignored, passive checks are not supported", where, param)
+				continue
+			}
+			var ok bool
+			if key, ok = agentdKeys[param]; !ok {
+				c.warn("%s: unsupported parameter %s ignored", where, param)
+				continue
+			}
+			values = []string{value}
+		}
+		for _, v := range values {
+			c.setAgentd(frag, defined, key, v, where, e)
+		}
+	}
+	return defined, scanner.Err()
+}
+
+// setAgentd stores one value of a zabbix_agentd.conf parameter: appended for
+// list keys, replaced otherwise.
+func (c *Config) setAgentd(frag *Config, defined map[string]bool, key, value, where string,
+	e *ValidationError) {
+	fd, _ := frag.field(key)
+	if fd.v.Kind() == reflect.Slice {
+		fd.v.Set(reflect.Append(fd.v, reflect.ValueOf(value)))
+	} else {
+		if defined[key] {
+			c.warn("%s: %s set again, the last value is used", where, key)
+		}
+		if err := setValue(fd.v, value); err != nil {
+			e.add("%s: %s: %v", where, key, err)
+			return
+		}
+	}
+	defined[key] = true
+}
+
+// splitServerActive returns host and port (possibly empty) of the first
+// entry of a ServerActive list: host, host:port, IPv6 or [IPv6]:port.
+func splitServerActive(value string) (host, port string, err error) {
+	first := strings.TrimSpace(strings.Split(value, ",")[0])
+	if first == "" {
+		return "", "", fmt.Errorf("empty server")
+	}
+	if net.ParseIP(first) != nil {
+		return first, "", nil
+	}
+	if !strings.Contains(first, ":") {
+		return first, "", nil
+	}
+	return net.SplitHostPort(first)
+}
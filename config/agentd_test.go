@@ -0,0 +1,72 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAgentdConf(t *testing.T) {
+	dir, done := writeConfigs(t, map[string]string{
+		"zabbix_agentd.conf": `# a zabbix_agentd.conf used unchanged
+Server=10.0.0.1
+ServerActive=zabbix.example.com:10052,backup.example.com
+Hostname=web-1
+DebugLevel=4
+LogType=console
+LogFileSize=2
+BufferSend=10
+BufferSize=500
+Timeout=5
+UserParameter=test.one,echo 1
+UserParameter=test.two,echo 2
+EnableRemoteCommands=0
+Include=items.tml
+`,
+		"items.tml": validBase + "\n[[item]]\nkey = \"agent.ping\"\ninterval = 60\n",
+	})
+	defer done()
+
+	c := load(t, filepath.Join(dir, "zabbix_agentd.conf"), nil)
+	for key, want := range map[string]string{
+		"server.ip":           `"zabbix.example.com"`,
+		"server.port":         "10052",
+		"agent.hostname":      `"web-1"`,
+		"agent.loglevel":      `"debug"`,
+		"agent.logtype":       `"console"`,
+		"agent.logfilesize":   "2",
+		"agent.buffersend":    "10",
+		"agent.buffersize":    "500",
+		"agent.timeout":       "5",
+		"agent.userparameter": `["test.one,echo 1", "test.two,echo 2"]`,
+	} {
+		if got := c.Value(key); got != want {
+			t.Errorf("%s = %s, want %s", key, got, want)
+		}
+	}
+	warnings := strings.Join(c.Warnings(), "\n")
+	for _, want := range []string{
+		"zabbix_agentd.conf:2: Server ignored, passive checks are not supported",
+		"ServerActive: only the first server zabbix.example.com is used",
+		"unsupported parameter EnableRemoteCommands ignored",
+	} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("no warning %q in\n%s", want, warnings)
+		}
+	}
+	if strings.Contains(warnings, "no [[item]]") {
+		t.Errorf("warned about items in\n%s", warnings)
+	}
+}
+
+// A config without items loads but warns that nothing is collected.
+func TestNoItemsWarning(t *testing.T) {
+	dir, done := writeConfigs(t, map[string]string{
+		"zabbix_agentd.conf": "ServerActive=127.0.0.1\nLogFile=\nLogType=console\n",
+	})
+	defer done()
+	c := load(t, filepath.Join(dir, "zabbix_agentd.conf"), Overrides{"agent.controlsocket": ""})
+	if warnings := strings.Join(c.Warnings(), "\n"); !strings.Contains(warnings, "no [[item]] defined") {
+		t.Errorf("no warning about items in\n%s", warnings)
+	}
+}
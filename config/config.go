@@ -0,0 +1,450 @@
+/*******************************************************************************
+* FileName:  config.go
+* Author: Victor
+* Date: 2019/08/25 10:12
+* Description: agent configuration: defaults, loading and validation
+* Project: zabbix_agent
+*******************************************************************************/
+
+// Package config loads the agent configuration file, fills in defaults for
+// every setting and validates the result before the agent starts using it.
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DefaultPath is the configuration file used when none is given on the
+// command line. It is relative to the working directory of the agent.
+const DefaultPath = "conf/conf.tml"
+
+// Config is the complete agent configuration.
+type Config struct {
+	// Include lists further config files merged after this one. Entries
+	// are glob patterns or directories, relative to the including file.
+	// The -include flag or $ZBX_INCLUDE replaces the list of the main file.
+	Include []string `toml:"include"`
+
+	Server Server `toml:"server"`
+	Agent  Agent  `toml:"agent"`
+	Log    Log    `toml:"log"`
+	Items  []Item `toml:"item"` // only from TOML, see parseAgentd
+
+	sources  map[string]string // key -> where its value came from
+	warnings []string
+}
+
+// Server describes the zabbix server the agent reports to.
+type Server struct {
+	Ip      string `toml:"ip"`
+	Port    int    `toml:"port"`
+	Version int    `toml:"version"` // zabbix version support 2,3,4
+}
+
+// Agent holds the settings of the agent process itself.
+type Agent struct {
+	// Port and Server are reserved for passive checks, which the agent
+	// does not serve yet: they are validated but not used.
+	Port      int    `toml:"port"`
+	LogLevel  string `toml:"loglevel"`  // trace, debug, info, warn, error
+	LogType   string `toml:"logtype"`   // file, console, syslog or journald
+	LogFile   string `toml:"logfile"`   // for logtype file, empty means standard error
+	LogFormat string `toml:"logformat"` // text or json
+	Hostname  string `toml:"hostname"`  // empty means the system host name
+
+	// LogSocket is the unix socket of logtype syslog or journald; empty
+	// means /dev/log or /run/systemd/journal/socket.
+	LogSocket string `toml:"logsocket"`
+
+	// Log file rotation: by size in MB and/or every hour, day or week.
+	// Backups beyond logmaxbackups or older than logmaxage days are
+	// removed; 0 disables a limit.
+	LogFileSize   int    `toml:"logfilesize"`
+	LogRotate     string `toml:"logrotate"` // hourly, daily, weekly or empty
+	LogMaxBackups int    `toml:"logmaxbackups"`
+	LogMaxAge     int    `toml:"logmaxage"`
+	LogCompress   bool   `toml:"logcompress"` // gzip rotated files
+
+	// LogQueue is the number of records queued for a background writer,
+	// so a slow log output does not hold up the checks; 0 writes them
+	// synchronously. LogDrop says which record goes when the queue is
+	// full: the oldest, the newest, or none by blocking the caller.
+	LogQueue int    `toml:"logqueue"`
+	LogDrop  string `toml:"logdrop"`
+
+	// LogRepeat is the window in seconds within which a record repeated
+	// by the same line of code is only counted, and written later as
+	// `last message "msg" repeated N times`; 0 writes every record. LogRate
+	// limits every line of code to that many records per second; 0 means
+	// no limit.
+	LogRepeat int `toml:"logrepeat"`
+	LogRate   int `toml:"lograte"`
+
+	Timeout int `toml:"timeout"` // seconds spent on one check, 1-30
+
+	// ControlSocket is the unix socket accepting runtime commands such as
+	// a config reload. Empty disables it.
+	ControlSocket string `toml:"controlsocket"`
+	// BufferSize is the number of values kept while the server cannot be
+	// reached; the oldest are dropped first.
+	BufferSize int `toml:"buffersize"`
+	// BufferSend is the number of seconds between two sends to the server.
+	BufferSend int `toml:"buffersend"`
+	// BufferFile keeps the values not sent yet when the agent exits, to be
+	// sent after the next start. Empty drops them.
+	BufferFile string `toml:"bufferfile"`
+
+	// RecoverPanics fails an item whose collector panics instead of
+	// crashing the agent.
+	RecoverPanics bool `toml:"recoverpanics"`
+	// HostRoot is where the built-in items find the proc, sys and etc
+	// trees of the monitored host, e.g. /host in a container.
+	HostRoot string `toml:"hostroot"`
+	// RPMCommand lists the installed rpm packages for system.sw.packages,
+	// one per line as name, version, arch, size and install time separated
+	// by tabs. It runs with HostRoot in $HOSTROOT, to be passed to rpm as
+	// --root, and is killed when the item times out. RPMFile, if set, is
+	// read for that list instead, e.g. when it is written by a cron job or
+	// rpm cannot run on the agent host.
+	RPMCommand string `toml:"rpmcommand"`
+	RPMFile    string `toml:"rpmfile"`
+
+	// Server lists the hosts allowed to connect for passive checks, see
+	// Port.
+	Server []string `toml:"server"`
+	// UserParameter entries have the zabbix_agentd form "key,command".
+	UserParameter []string `toml:"userparameter"`
+}
+
+// Log holds the per-component logging settings.
+type Log struct {
+	// Levels sets the log level of a component, overriding agent.loglevel:
+	// sender = "debug". A collector:<name> entry, e.g. collector:vfs.fs.size,
+	// wins over a collector entry.
+	Levels map[string]string `toml:"levels"`
+}
+
+// Item is an active check: the item key and how often, in seconds, it is
+// collected.
+type Item struct {
+	Key      string `toml:"key"`
+	Interval int    `toml:"interval"`
+}
+
+// LogLevels lists the values accepted by agent.loglevel.
+var LogLevels = []string{"trace", "debug", "info", "warn", "error"}
+
+// LogComponents lists the components accepted in log.levels, besides
+// collector:<item key name>.
+var LogComponents = []string{"sender", "scheduler", "listener", "collector"}
+
+// ValidLogComponent reports whether name is a component of log.levels.
+func ValidLogComponent(name string) bool {
+	if strings.HasPrefix(name, "collector:") {
+		return len(name) > len("collector:")
+	}
+	return oneOf(name, LogComponents)
+}
+
+// LogTypes lists the values accepted by agent.logtype.
+var LogTypes = []string{"file", "console", "syslog", "journald"}
+
+// LogFormats lists the values accepted by agent.logformat.
+var LogFormats = []string{"text", "json"}
+
+// LogDrops lists the values accepted by agent.logdrop.
+var LogDrops = []string{"oldest", "newest", "block"}
+
+// LogRotations lists the values accepted by agent.logrotate.
+var LogRotations = []string{"", "hourly", "daily", "weekly"}
+
+// DefaultRPMCommand is the default agent.rpmcommand.
+const DefaultRPMCommand = `rpm -qa --root "$HOSTROOT" --queryformat ` +
+	`'%{NAME}\t%{VERSION}-%{RELEASE}\t%{ARCH}\t%{SIZE}\t%{INSTALLTIME}\n'`
+
+// Default returns a configuration with every field set to its default value.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Ip:      "127.0.0.1",
+			Port:    10051,
+			Version: 4,
+		},
+		Agent: Agent{
+			Port:      10050,
+			LogLevel:  "info",
+			LogType:   "file",
+			LogFile:   "./log/agent.log",
+			LogFormat: "text",
+			Timeout:   3,
+
+			LogFileSize:   10,
+			LogMaxBackups: 5,
+			LogDrop:       "oldest",
+			LogRepeat:     60,
+
+			ControlSocket: "/tmp/zabbix_agent.sock",
+			BufferSize:    1000,
+			BufferSend:    5,
+			RecoverPanics: true,
+			HostRoot:      "/",
+			RPMCommand:    DefaultRPMCommand,
+		},
+	}
+}
+
+// Load reads the configuration file at path and every file it includes on
+// top of the defaults, then applies environment overrides (see EnvName) and
+// the command line overrides, in that order of precedence, and validates
+// the result. Files ending in .conf are read in the zabbix_agentd.conf
+// format, everything else as TOML.
+// Decoding problems and validation problems are returned together so they
+// can all be fixed in one go. Only a file that cannot be read or parsed
+// yields a nil *Config; otherwise the config is returned even when invalid
+// so it can still be inspected.
+func Load(path string, overrides Overrides) (*Config, error) {
+	c := Default()
+	verr := &ValidationError{Path: path}
+	include, source := includeOverride(overrides, verr)
+	if err := c.loadFile(path, include, verr, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+	if include != nil {
+		c.setSource("include", source)
+	}
+	c.applyEnv(verr)
+	c.applyFlags(overrides, verr)
+	c.validate(verr)
+	if len(c.Items) == 0 {
+		c.warn("no [[item]] defined, nothing is collected: items only come from TOML files, " +
+			"the server is not asked for active checks")
+	}
+	if len(verr.Problems) > 0 {
+		return c, verr
+	}
+	return c, nil
+}
+
+// Warnings returns the non fatal problems found while loading, such as a
+// setting defined by more than one file.
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
+
+func (c *Config) warn(format string, v ...interface{}) {
+	c.warnings = append(c.warnings, fmt.Sprintf(format, v...))
+}
+
+// Hostname returns the host name the agent reports its values under.
+func (c *Config) Hostname() string {
+	if c.Agent.Hostname != "" {
+		return c.Agent.Hostname
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return name
+}
+
+// ServerAddress returns the host:port the agent sends its data to.
+func (c *Config) ServerAddress() string {
+	return net.JoinHostPort(c.Server.Ip, fmt.Sprint(c.Server.Port))
+}
+
+// ValidationError collects every problem found in a configuration.
+type ValidationError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *ValidationError) add(format string, v ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, v...))
+}
+
+func (e *ValidationError) Error() string {
+	prefix := "invalid config"
+	if e.Path != "" {
+		prefix += " " + e.Path
+	}
+	return prefix + ": " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the configuration and returns a *ValidationError listing
+// every problem, or nil if the configuration is usable.
+func (c *Config) Validate() error {
+	e := &ValidationError{}
+	c.validate(e)
+	if len(e.Problems) > 0 {
+		return e
+	}
+	return nil
+}
+
+func (c *Config) validate(e *ValidationError) {
+	if c.Server.Ip == "" {
+		e.add("server.ip: must not be empty")
+	} else if !validHost(c.Server.Ip) {
+		e.add("server.ip: %q is neither an IP address nor a host name", c.Server.Ip)
+	}
+	checkPort(e, "server.port", c.Server.Port)
+	switch c.Server.Version {
+	case 2, 3, 4:
+	default:
+		e.add("server.version: %d is not supported, use 2, 3 or 4", c.Server.Version)
+	}
+
+	checkPort(e, "agent.port", c.Agent.Port)
+	if !oneOf(c.Agent.LogLevel, LogLevels) {
+		e.add("agent.loglevel: %q is not one of %s", c.Agent.LogLevel, strings.Join(LogLevels, ", "))
+	}
+	if c.Agent.Timeout < 1 || c.Agent.Timeout > 30 {
+		e.add("agent.timeout: %d is out of range 1-30", c.Agent.Timeout)
+	}
+	if c.Agent.Hostname != "" && len(c.Agent.Hostname) > 128 {
+		e.add("agent.hostname: longer than 128 characters")
+	}
+	for _, peer := range c.Agent.Server {
+		if !validHost(peer) {
+			if _, _, err := net.ParseCIDR(peer); err != nil {
+				e.add("agent.server: %q is neither an address, a network nor a host name", peer)
+			}
+		}
+	}
+	for _, up := range c.Agent.UserParameter {
+		if i := strings.Index(up, ","); i <= 0 || i == len(up)-1 {
+			e.add("agent.userparameter: %q is not of the form key,command", up)
+		}
+	}
+	if !oneOf(c.Agent.LogType, LogTypes) {
+		e.add("agent.logtype: %q is not one of %s", c.Agent.LogType, strings.Join(LogTypes, ", "))
+	}
+	if c.Agent.LogType == "file" && c.Agent.LogFile != "" {
+		checkDir(e, "agent.logfile", "log", c.Agent.LogFile)
+	}
+	if c.Agent.LogSocket != "" && !filepath.IsAbs(c.Agent.LogSocket) {
+		e.add("agent.logsocket: %q is not an absolute path", c.Agent.LogSocket)
+	}
+	if !oneOf(c.Agent.LogFormat, LogFormats) {
+		e.add("agent.logformat: %q is not one of %s", c.Agent.LogFormat, strings.Join(LogFormats, ", "))
+	}
+	if c.Agent.LogFileSize < 0 || c.Agent.LogFileSize > 1024 {
+		e.add("agent.logfilesize: %d is out of range 0-1024", c.Agent.LogFileSize)
+	}
+	if !oneOf(c.Agent.LogRotate, LogRotations) {
+		e.add("agent.logrotate: %q is not one of hourly, daily, weekly", c.Agent.LogRotate)
+	}
+	if c.Agent.LogMaxBackups < 0 {
+		e.add("agent.logmaxbackups: must not be negative")
+	}
+	if c.Agent.LogMaxAge < 0 {
+		e.add("agent.logmaxage: must not be negative")
+	}
+	if c.Agent.LogQueue < 0 || c.Agent.LogQueue > 1000000 {
+		e.add("agent.logqueue: %d is out of range 0-1000000", c.Agent.LogQueue)
+	}
+	if !oneOf(c.Agent.LogDrop, LogDrops) {
+		e.add("agent.logdrop: %q is not one of %s", c.Agent.LogDrop, strings.Join(LogDrops, ", "))
+	}
+	if c.Agent.LogRepeat < 0 || c.Agent.LogRepeat > 86400 {
+		e.add("agent.logrepeat: %d is out of range 0-86400", c.Agent.LogRepeat)
+	}
+	if c.Agent.LogRate < 0 {
+		e.add("agent.lograte: must not be negative")
+	}
+	if c.Agent.ControlSocket != "" {
+		checkDir(e, "agent.controlsocket", "socket", c.Agent.ControlSocket)
+	}
+	if c.Agent.BufferSize < 1 || c.Agent.BufferSize > 65535 {
+		e.add("agent.buffersize: %d is out of range 1-65535", c.Agent.BufferSize)
+	}
+	if c.Agent.BufferSend < 1 || c.Agent.BufferSend > 3600 {
+		e.add("agent.buffersend: %d is out of range 1-3600", c.Agent.BufferSend)
+	}
+	if fi, err := os.Stat(c.Agent.HostRoot); err != nil || !fi.IsDir() {
+		e.add("agent.hostroot: %q is not a directory", c.Agent.HostRoot)
+	}
+	if c.Agent.BufferFile != "" {
+		checkDir(e, "agent.bufferfile", "buffer", c.Agent.BufferFile)
+	}
+	if c.Agent.RPMFile != "" && !filepath.IsAbs(c.Agent.RPMFile) {
+		e.add("agent.rpmfile: %q is not an absolute path", c.Agent.RPMFile)
+	}
+	components := make([]string, 0, len(c.Log.Levels))
+	for name := range c.Log.Levels {
+		components = append(components, name)
+	}
+	sort.Strings(components)
+	for _, name := range components {
+		if !ValidLogComponent(name) {
+			e.add("log.levels: unknown component %q, use %s or collector:<key name>",
+				name, strings.Join(LogComponents, ", "))
+		}
+		if level := c.Log.Levels[name]; !oneOf(level, LogLevels) {
+			e.add("log.levels: %s: %q is not one of %s", name, level, strings.Join(LogLevels, ", "))
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, item := range c.Items {
+		if item.Key == "" {
+			e.add("item[%d]: key must not be empty", i)
+		} else if seen[item.Key] {
+			e.add("item[%d]: %s is defined more than once", i, item.Key)
+		}
+		seen[item.Key] = true
+		if item.Interval < 1 || item.Interval > 86400 {
+			e.add("item[%d]: interval %d is out of range 1-86400", i, item.Interval)
+		}
+	}
+}
+
+// checkDir verifies that the directory holding file exists.
+func checkDir(e *ValidationError, key, what, file string) {
+	dir := filepath.Dir(file)
+	if fi, err := os.Stat(dir); err != nil {
+		e.add("%s: %s directory %s does not exist", key, what, dir)
+	} else if !fi.IsDir() {
+		e.add("%s: %s is not a directory", key, dir)
+	}
+}
+
+func checkPort(e *ValidationError, key string, port int) {
+	if port < 1 || port > 65535 {
+		e.add("%s: %d is out of range 1-65535", key, port)
+	}
+}
+
+func oneOf(s string, list []string) bool {
+	for _, v := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+var hostLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// validHost reports whether s is an IP address or a syntactically valid
+// host name. It does not resolve the name.
+func validHost(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	if len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(s, "."), ".") {
+		if !hostLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
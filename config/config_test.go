@@ -0,0 +1,223 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Each level wins over the ones before it: default < file < env < flag.
+func TestPrecedence(t *testing.T) {
+	dir, done := writeConfigs(t, map[string]string{
+		"main.tml": validBase + `
+hostname = "from-file"
+timeout = 5
+buffersend = 10
+loglevel = "warn"
+`,
+	})
+	defer done()
+	defer setenv("ZBX_AGENT_TIMEOUT", "7")()
+	defer setenv("ZBX_AGENT_BUFFERSEND", "20")()
+	defer setenv("ZBX_SERVER_PORT", "10052")()
+
+	c := load(t, filepath.Join(dir, "main.tml"), Overrides{"agent.buffersend": "30", "server.ip": "10.0.0.2"})
+	for key, want := range map[string][2]string{
+		"server.version":   {"4", "default"},
+		"agent.hostname":   {`"from-file"`, "file " + filepath.Join(dir, "main.tml")},
+		"server.port":      {"10052", "env ZBX_SERVER_PORT"},
+		"agent.timeout":    {"7", "env ZBX_AGENT_TIMEOUT"},
+		"server.ip":        {`"10.0.0.2"`, "flag -server.ip"},
+		"agent.buffersend": {"30", "flag -agent.buffersend"},
+	} {
+		if got, source := c.Value(key), c.Source(key); got != want[0] || source != want[1] {
+			t.Errorf("%s = %s from %q, want %s from %q", key, got, source, want[0], want[1])
+		}
+	}
+	if c.Agent.LogLevel != "warn" || c.Agent.Timeout != 7 || c.Agent.BufferSend != 30 {
+		t.Errorf("got loglevel %q, timeout %d, buffersend %d", c.Agent.LogLevel, c.Agent.Timeout,
+			c.Agent.BufferSend)
+	}
+
+	// a bad override is a problem of its own, reported with the others
+	defer setenv("ZBX_AGENT_TIMEOUT", "soon")()
+	_, err := Load(filepath.Join(dir, "main.tml"), Overrides{"agent.port": "0"})
+	for _, want := range []string{
+		`agent.timeout: env ZBX_AGENT_TIMEOUT: "soon" is not an integer`,
+		"agent.port: 0 is out of range 1-65535",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v, want %q", err, want)
+		}
+	}
+}
+
+func TestIncludeExpansion(t *testing.T) {
+	dir, done := writeConfigs(t, map[string]string{
+		"main.tml": `include = ["conf.d", "extra/*.tml", "loop.tml"]
+[agent]
+logfile = ""
+controlsocket = ""
+userparameter = ["main.one,echo 1"]
+[log.levels]
+sender = "debug"
+`,
+		// a directory is read in name order
+		"conf.d/10-items.tml": "[[item]]\nkey = \"agent.ping\"\ninterval = 60\n",
+		"conf.d/20-agent.tml": "[agent]\ntimeout = 4\nuserparameter = [\"d.two,echo 2\"]\n",
+		"conf.d/30-agent.tml": "[agent]\ntimeout = 6\n[log.levels]\nscheduler = \"trace\"\n",
+		"loop.tml":            `include = ["main.tml"]` + "\n[[item]]\nkey = \"agent.version\"\ninterval = 300\n",
+	})
+	defer done()
+	main := filepath.Join(dir, "main.tml")
+
+	c := load(t, main, nil)
+	if got := c.Value("agent.userparameter"); got != `["main.one,echo 1", "d.two,echo 2"]` {
+		t.Errorf("lists not appended: %s", got)
+	}
+	if got := c.Value("log.levels"); got != `{scheduler = "trace", sender = "debug"}` {
+		t.Errorf("tables not merged: %s", got)
+	}
+	if c.Agent.Timeout != 6 || len(c.Items) != 2 || c.Items[1].Key != "agent.version" {
+		t.Errorf("got timeout %d, items %v", c.Agent.Timeout, c.Items)
+	}
+	last := filepath.Join(dir, "conf.d", "30-agent.tml")
+	if got := c.Source("agent.timeout"); got != "file "+last {
+		t.Errorf("timeout source = %q", got)
+	}
+	if got := c.Source("log.levels"); got != "file "+main+", "+last {
+		t.Errorf("log.levels source = %q", got)
+	}
+	warnings := strings.Join(c.Warnings(), "\n")
+	for _, want := range []string{
+		"agent.timeout: " + last + " overrides the value from " +
+			filepath.Join(dir, "conf.d", "20-agent.tml"),
+		"main.tml: already loaded, include ignored",
+	} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("no warning %q in\n%s", want, warnings)
+		}
+	}
+
+	// a file named plainly must exist, a glob may match nothing
+	dir2, done2 := writeConfigs(t, map[string]string{
+		"main.tml": `include = ["none/*.tml", "none.tml"]` + validBase,
+	})
+	defer done2()
+	_, err := Load(filepath.Join(dir2, "main.tml"), nil)
+	missing := "include: " + filepath.Join(dir2, "none.tml") + " does not exist"
+	if err == nil || !strings.Contains(err.Error(), missing) || strings.Contains(err.Error(), "none/*.tml") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir, done := writeConfigs(t, map[string]string{
+		"main.tml": `
+[server]
+ip = "not a host"
+version = 5
+[agent]
+loglevel = "verbose"
+logfile = "/nonexistent/agent.log"
+controlsocket = ""
+timeout = 31
+buffersize = 0
+userparameter = ["nocommand"]
+unknown = 1
+[log.levels]
+listener = "loud"
+cpu = "debug"
+[[item]]
+key = "agent.ping"
+interval = 0
+[[item]]
+key = "agent.ping"
+interval = 60
+`,
+	})
+	defer done()
+	c, err := Load(filepath.Join(dir, "main.tml"), nil)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("got %v, want a *ValidationError", err)
+	}
+	if c == nil {
+		t.Fatal("no config returned with the problems")
+	}
+	want := []string{
+		"agent.unknown: unknown key",
+		`server.ip: "not a host" is neither an IP address nor a host name`,
+		"server.version: 5 is not supported, use 2, 3 or 4",
+		`agent.loglevel: "verbose" is not one of trace, debug, info, warn, error`,
+		"agent.timeout: 31 is out of range 1-30",
+		`agent.userparameter: "nocommand" is not of the form key,command`,
+		"agent.logfile: log directory /nonexistent does not exist",
+		"agent.buffersize: 0 is out of range 1-65535",
+		`log.levels: unknown component "cpu", use sender, scheduler, listener, collector or collector:<key name>`,
+		`log.levels: listener: "loud" is not one of trace, debug, info, warn, error`,
+		"item[0]: interval 0 is out of range 1-86400",
+		"item[1]: agent.ping is defined more than once",
+	}
+	if got := strings.Join(verr.Problems, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("got problems\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+
+	// the defaults are valid but for the log directory, ./log, not found here
+	c = Default()
+	c.Agent.LogFile = ""
+	if err := c.Validate(); err != nil {
+		t.Errorf("default config: %v", err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	dir, done := writeConfigs(t, map[string]string{"main.tml": validBase + "timeout = 5\n"})
+	defer done()
+	c := load(t, filepath.Join(dir, "main.tml"), Overrides{"agent.userparameter": "a.b,echo 1,2"})
+	var buf bytes.Buffer
+	if err := c.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, re := range []string{
+		`(?m)^KEY +VALUE +SOURCE$`,
+		`(?m)^server\.ip +"127\.0\.0\.1" +default$`,
+		`(?m)^agent\.timeout +5 +file .*main\.tml$`,
+		`(?m)^agent\.userparameter +\["a\.b,echo 1,2"\] +flag -agent\.userparameter$`,
+		`(?m)^item +\[\] +default$`,
+	} {
+		if !regexp.MustCompile(re).Match(buf.Bytes()) {
+			t.Errorf("no line matching %s in\n%s", re, buf.String())
+		}
+	}
+	if n := strings.Count(buf.String(), "\n"); n != len(c.Keys())+1 {
+		t.Errorf("%d lines for %d keys", n, len(c.Keys()))
+	}
+}
+
+func TestRegisterFlags(t *testing.T) {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	overrides := RegisterFlags(fs)
+	err := fs.Parse([]string{"-agent.timeout", "9", `-log.levels={sender = "trace"}`,
+		`-agent.server=["10.0.0.1", "10.0.1.0/24"]`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.Lookup("item") != nil {
+		t.Error("[[item]] has a flag")
+	}
+	c := Default()
+	c.applyFlags(overrides, &ValidationError{})
+	if c.Agent.Timeout != 9 || c.Log.Levels["sender"] != "trace" || len(c.Agent.Server) != 2 {
+		t.Errorf("got timeout %d, log levels %v, server %v", c.Agent.Timeout, c.Log.Levels, c.Agent.Server)
+	}
+	if err := c.Set("agent.server", "[1, 2]", "test"); err == nil {
+		t.Error("a list of numbers was set")
+	}
+	if err := c.Set("agent.nosuchkey", "1", "test"); err == nil {
+		t.Error("an unknown key was set")
+	}
+}
@@ -0,0 +1,189 @@
+/*******************************************************************************
+* FileName:  include.go
+* Author: Victor
+* Date: 2019/08/26 09:05
+* Description: merging of included config fragments (conf.d)
+* Project: zabbix_agent
+*******************************************************************************/
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// loadFile merges the file at path into c and then, depth first, every file
+// it includes. Lists are appended, tables merged entry by entry, any other
+// value set by a later file replaces the earlier one and the override is
+// recorded as a warning. A non nil include replaces the includes of the
+// file, see includeOverride.
+// seen guards against include loops.
+func (c *Config) loadFile(path string, include []string, e *ValidationError, seen map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("load config %s: %v", path, err)
+	}
+	if seen[abs] {
+		c.warn("%s: already loaded, include ignored", path)
+		return nil
+	}
+	seen[abs] = true
+
+	var frag Config
+	var defined map[string]bool
+	if IsAgentdConf(path) {
+		defined, err = c.parseAgentd(path, &frag, e)
+	} else {
+		defined, err = parseTOML(path, &frag, e)
+	}
+	if err != nil {
+		return fmt.Errorf("load config %s: %v", path, err)
+	}
+	c.merge(&frag, defined, path)
+	if include != nil {
+		frag.Include = include
+		c.Include = append([]string(nil), include...)
+	}
+
+	for _, pattern := range frag.Include {
+		files, err := expandInclude(filepath.Dir(path), pattern)
+		if err != nil {
+			e.add("%s: %v", where(e, path, "include"), err)
+			continue
+		}
+		for _, file := range files {
+			if err := c.loadFile(file, nil, e, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// includeOverride returns the include list given by the -include flag or,
+// failing that, by $ZBX_INCLUDE, and its source; nil if neither is set. It
+// replaces the includes of the main config file before they are expanded,
+// so it is not applied with the other overrides.
+func includeOverride(overrides Overrides, e *ValidationError) ([]string, string) {
+	s, ok := overrides["include"]
+	source := SourceFlag + " -include"
+	if !ok {
+		name := EnvName("include")
+		if s, ok = os.LookupEnv(name); !ok {
+			return nil, ""
+		}
+		source = SourceEnv + " " + name
+	}
+	include := []string{} // empty drops the includes of the file
+	if strings.TrimSpace(s) == "" {
+		return include, source
+	}
+	if err := setValue(reflect.ValueOf(&include).Elem(), s); err != nil {
+		e.add("include: %s: %v", source, err)
+		return nil, ""
+	}
+	return include, source
+}
+
+// where prefixes key with path unless path is the main config file, whose
+// name is already part of the error.
+func where(e *ValidationError, path, key string) string {
+	if path == e.Path {
+		return key
+	}
+	return path + ": " + key
+}
+
+// parseTOML decodes the TOML file at path into frag and returns the keys it
+// defines.
+func parseTOML(path string, frag *Config, e *ValidationError) (map[string]bool, error) {
+	md, err := toml.DecodeFile(path, frag)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range md.Undecoded() {
+		e.add("%s: unknown key", where(e, path, key.String()))
+	}
+	defined := make(map[string]bool)
+	for _, f := range frag.fields() {
+		if md.IsDefined(strings.Split(f.key, ".")...) {
+			defined[f.key] = true
+		}
+	}
+	return defined, nil
+}
+
+// merge copies every key defined by the file path from frag into c.
+func (c *Config) merge(frag *Config, defined map[string]bool, path string) {
+	dst := c.fields()
+	for i, f := range frag.fields() {
+		if !defined[f.key] {
+			continue
+		}
+		d := dst[i]
+		prev := c.Source(f.key)
+		source := SourceFile + " " + path
+		if f.v.Kind() == reflect.Slice {
+			if strings.HasPrefix(prev, SourceFile+" ") {
+				source = prev + ", " + path
+			}
+			d.v.Set(reflect.AppendSlice(d.v, f.v))
+		} else if f.v.Kind() == reflect.Map {
+			// tables are merged entry by entry, the later file wins
+			if strings.HasPrefix(prev, SourceFile+" ") {
+				source = prev + ", " + path
+			}
+			if d.v.IsNil() {
+				d.v.Set(reflect.MakeMap(d.v.Type()))
+			}
+			for _, k := range f.v.MapKeys() {
+				d.v.SetMapIndex(k, f.v.MapIndex(k))
+			}
+		} else {
+			if strings.HasPrefix(prev, SourceFile+" ") {
+				c.warn("%s: %s overrides the value from %s",
+					f.key, path, strings.TrimPrefix(prev, SourceFile+" "))
+			}
+			d.v.Set(f.v)
+		}
+		c.setSource(f.key, source)
+	}
+}
+
+// expandInclude resolves one include entry relative to dir. A directory
+// stands for every file in it; a glob may match nothing, a plain file name
+// must exist. The result is sorted so fragments merge in a stable order.
+func expandInclude(dir, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	if fi, err := os.Stat(pattern); err == nil && fi.IsDir() {
+		infos, err := ioutil.ReadDir(pattern)
+		if err != nil {
+			return nil, err
+		}
+		var files []string
+		for _, info := range infos {
+			if info.Mode().IsRegular() {
+				files = append(files, filepath.Join(pattern, info.Name()))
+			}
+		}
+		return files, nil
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("bad pattern %q: %v", pattern, err)
+	}
+	if len(files) == 0 && !strings.ContainsAny(pattern, `*?[\`) {
+		return nil, fmt.Errorf("%s does not exist", pattern)
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeConfigs writes files, by name, into a temporary directory and
+// returns it. The directory is removed by calling done.
+func writeConfigs(t *testing.T, files map[string]string) (dir string, done func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// setenv sets the environment variable name until the returned function
+// is called.
+func setenv(name, value string) func() {
+	old, ok := os.LookupEnv(name)
+	os.Setenv(name, value)
+	return func() {
+		if ok {
+			os.Setenv(name, old)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
+
+// load loads the config at path, failing the test on any problem.
+func load(t *testing.T, path string, overrides Overrides) *Config {
+	t.Helper()
+	c, err := Load(path, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// validBase is the agent section of a main config file that validates in
+// a test: no log file or control socket to create.
+const validBase = `
+[agent]
+logfile = ""
+controlsocket = ""
+`
+
+var includeFiles = map[string]string{
+	"main.tml":   `include = ["a.tml"]` + "\n" + validBase,
+	"a.tml":      "[agent]\nhostname = \"from-a\"\n",
+	"b.tml":      "[agent]\nhostname = \"from-b\"\n",
+	"c/c1.tml":   "[agent]\ntimeout = 7\n",
+	"c/more.tml": "[agent]\nbuffersend = 9\n",
+}
+
+func TestIncludeFlag(t *testing.T) {
+	dir, done := writeConfigs(t, includeFiles)
+	defer done()
+	main := filepath.Join(dir, "main.tml")
+
+	c := load(t, main, Overrides{"include": `["b.tml", "c"]`})
+	if c.Agent.Hostname != "from-b" || c.Agent.Timeout != 7 || c.Agent.BufferSend != 9 {
+		t.Errorf("-include not loaded: hostname %q, timeout %d, buffersend %d",
+			c.Agent.Hostname, c.Agent.Timeout, c.Agent.BufferSend)
+	}
+	if got := c.Value("include"); got != `["b.tml", "c"]` {
+		t.Errorf("include = %s", got)
+	}
+	if got := c.Source("include"); got != "flag -include" {
+		t.Errorf("include source = %q", got)
+	}
+
+	// an empty list drops the includes of the file
+	c = load(t, main, Overrides{"include": ""})
+	if c.Agent.Hostname != "" || len(c.Include) != 0 {
+		t.Errorf("-include= loaded %v: hostname %q", c.Include, c.Agent.Hostname)
+	}
+
+	if _, err := Load(main, Overrides{"include": "missing.tml"}); err == nil {
+		t.Error("a missing -include file loaded")
+	}
+}
+
+func TestIncludeEnv(t *testing.T) {
+	dir, done := writeConfigs(t, includeFiles)
+	defer done()
+	main := filepath.Join(dir, "main.tml")
+	defer setenv("ZBX_INCLUDE", "b.tml")()
+
+	c := load(t, main, nil)
+	if c.Agent.Hostname != "from-b" {
+		t.Errorf("$ZBX_INCLUDE not loaded: hostname %q", c.Agent.Hostname)
+	}
+	if got := c.Source("include"); got != "env ZBX_INCLUDE" {
+		t.Errorf("include source = %q", got)
+	}
+
+	// the flag wins over the environment
+	c = load(t, main, Overrides{"include": "a.tml"})
+	if c.Agent.Hostname != "from-a" || c.Source("include") != "flag -include" {
+		t.Errorf("with both: hostname %q from %s", c.Agent.Hostname, c.Source("include"))
+	}
+}
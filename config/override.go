@@ -0,0 +1,288 @@
+/*******************************************************************************
+* FileName:  override.go
+* Author: Victor
+* Date: 2019/08/25 16:40
+* Description: environment and command line overrides for config keys
+* Project: zabbix_agent
+*******************************************************************************/
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BurntSushi/toml"
+)
+
+// EnvPrefix starts the name of every environment variable that overrides a
+// config key: server.ip is overridden by ZBX_SERVER_IP.
+const EnvPrefix = "ZBX_"
+
+// Sources of a config value, from lowest to highest precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// EnvName returns the environment variable overriding key.
+func EnvName(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.Replace(key, ".", "_", -1))
+}
+
+// field is a single settable config key, e.g. "server.ip".
+type field struct {
+	key string
+	v   reflect.Value
+}
+
+// fields lists every key of c in declaration order. Keys are built from the
+// toml tags of the section and of the field; top level keys have no section.
+func (c *Config) fields() []field {
+	var list []field
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i).Tag.Get("toml")
+		if section == "" {
+			continue
+		}
+		sv := root.Field(i)
+		if sv.Kind() != reflect.Struct {
+			list = append(list, field{key: section, v: sv})
+			continue
+		}
+		for j := 0; j < sv.NumField(); j++ {
+			name := sv.Type().Field(j).Tag.Get("toml")
+			if name == "" || name == "-" {
+				continue
+			}
+			list = append(list, field{key: section + "." + name, v: sv.Field(j)})
+		}
+	}
+	return list
+}
+
+func (c *Config) field(key string) (field, bool) {
+	for _, f := range c.fields() {
+		if f.key == key {
+			return f, true
+		}
+	}
+	return field{}, false
+}
+
+// Keys returns every config key in declaration order.
+func (c *Config) Keys() []string {
+	var keys []string
+	for _, f := range c.fields() {
+		keys = append(keys, f.key)
+	}
+	return keys
+}
+
+// Value returns the effective value of key as shown by Dump, or "" for an
+// unknown key.
+func (c *Config) Value(key string) string {
+	f, ok := c.field(key)
+	if !ok {
+		return ""
+	}
+	return formatValue(f.v)
+}
+
+// Source reports where the effective value of key came from: one of the
+// Source constants, followed by the file, variable or flag name.
+func (c *Config) Source(key string) string {
+	if s, ok := c.sources[key]; ok {
+		return s
+	}
+	return SourceDefault
+}
+
+func (c *Config) setSource(key, source string) {
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	c.sources[key] = source
+}
+
+// Set parses value into key and records source as its origin.
+func (c *Config) Set(key, value, source string) error {
+	f, ok := c.field(key)
+	if !ok {
+		return fmt.Errorf("%s: unknown key", key)
+	}
+	if err := setValue(f.v, value); err != nil {
+		return fmt.Errorf("%s: %s: %v", key, source, err)
+	}
+	c.setSource(key, source)
+	return nil
+}
+
+func setValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("can only be set in a config file")
+		}
+		// A list is written in TOML array syntax, anything else is taken
+		// as a list of one: user parameters contain commas themselves.
+		var list struct{ V []string }
+		if strings.HasPrefix(strings.TrimSpace(s), "[") {
+			if _, err := toml.Decode("V = "+s, &list); err != nil {
+				return fmt.Errorf("%q is not a list of strings", s)
+			}
+		} else {
+			list.V = []string{s}
+		}
+		v.Set(reflect.ValueOf(list.V))
+	case reflect.Map:
+		// Tables are written in TOML inline table syntax and replace the
+		// whole table: {sender = "debug", scheduler = "info"}.
+		var table struct{ V map[string]string }
+		if _, err := toml.Decode("V = "+s, &table); err != nil {
+			return fmt.Errorf("%q is not a table of strings", s)
+		}
+		v.Set(reflect.ValueOf(table.V))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// bareKey matches the TOML keys that need no quotes.
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func formatValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return strconv.Quote(v.String())
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatValue(v.Index(i))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case reflect.Map:
+		keys := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		items := make([]string, len(keys))
+		for i, k := range keys {
+			name := k
+			if !bareKey.MatchString(k) {
+				name = strconv.Quote(k)
+			}
+			items[i] = name + " = " + formatValue(v.MapIndex(reflect.ValueOf(k)))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	case reflect.Struct:
+		items := make([]string, v.NumField())
+		for i := range items {
+			items[i] = v.Type().Field(i).Tag.Get("toml") + " = " + formatValue(v.Field(i))
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// applyEnv overrides every key that has its environment variable set but
+// include, which is applied while loading.
+func (c *Config) applyEnv(e *ValidationError) {
+	for _, f := range c.fields() {
+		if f.key == "include" {
+			continue
+		}
+		name := EnvName(f.key)
+		if s, ok := os.LookupEnv(name); ok {
+			if err := c.Set(f.key, s, SourceEnv+" "+name); err != nil {
+				e.add("%v", err)
+			}
+		}
+	}
+}
+
+// applyFlags overrides keys given on the command line, in key order so the
+// reported problems are stable.
+func (c *Config) applyFlags(overrides map[string]string, e *ValidationError) {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		if key != "include" { // applied while loading
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := c.Set(key, overrides[key], SourceFlag+" -"+key); err != nil {
+			e.add("%v", err)
+		}
+	}
+}
+
+// Overrides holds config values given on the command line, keyed by config
+// key.
+type Overrides map[string]string
+
+type overrideFlag struct {
+	key string
+	m   Overrides
+}
+
+func (f overrideFlag) String() string { return "" }
+
+func (f overrideFlag) Set(s string) error {
+	f.m[f.key] = s
+	return nil
+}
+
+// RegisterFlags defines one flag per config key on fs, named after the key
+// (-server.ip, -agent.loglevel, ...). Only flags actually given end up in
+// the returned Overrides.
+func RegisterFlags(fs *flag.FlagSet) Overrides {
+	m := make(Overrides)
+	def := Default()
+	for _, f := range def.fields() {
+		if f.v.Kind() == reflect.Slice && f.v.Type().Elem().Kind() != reflect.String {
+			continue // tables such as [[item]] only come from files
+		}
+		fs.Var(overrideFlag{key: f.key, m: m}, f.key,
+			fmt.Sprintf("override %s (env %s, default %s)", f.key, EnvName(f.key), formatValue(f.v)))
+	}
+	return m
+}
+
+// Dump writes the effective value of every key together with its source.
+func (c *Config) Dump(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, f := range c.fields() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", f.key, formatValue(f.v), c.Source(f.key))
+	}
+	return tw.Flush()
+}
@@ -1,48 +1,103 @@
 package main
 
 import (
+	"./agent"
+	"./config"
 	"./pkg"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
-var ch = make(chan string)
-
-func activeCheck () {
-	var data pkg.MajorData
+func main() {
+	confPath := flag.String("c", config.DefaultPath, "path to the configuration file")
+	control := flag.String("R", "", "send a runtime control `command` to the running agent (try help)")
+	overrides := config.RegisterFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+	load := func() (*config.Config, error) {
+		return config.Load(*confPath, overrides)
+	}
+	conf, err := load()
+	if conf != nil {
+		for _, w := range conf.Warnings() {
+			log.Println("config:", w)
+		}
+	}
 
-	var values []pkg.MinorData
-	var value pkg.MinorData
-	value = pkg.MinorData{
-		Host:  "host_test",
-		Key:   "key_test[\"{$URL}\",\"github\",\"{$HOST}\",\"space_use\"]",
-		Value: 99.87,
-		Clock: 1566481943,
+	switch args := flag.Args(); {
+	case len(args) == 0:
+	case len(args) == 2 && args[0] == "config" && args[1] == "dump":
+		if conf != nil {
+			conf.Dump(os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
 	}
-	values = append(values, value)
-	data = pkg.MajorData{
-		Request: "agent data",
-		Data: values ,
+
+	// A broken config must not keep us from asking the running agent to
+	// reload a fixed one, so only the socket path has to be known.
+	if *control != "" && conf != nil {
+		reply, err := agent.Control(conf.Agent.ControlSocket, *control)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(reply)
+		return
 	}
-	jsonBytes,err := json.Marshal(data)
 	if err != nil {
-		log.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	jsonString := string(jsonBytes)
+
+	a, err := agent.New(conf, load)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	data2 := []byte(jsonString)
-	res,err := pkg.DataSender(data2)
-	if err != nil {
-		fmt.Println("error",err)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		for sig := range sigs {
+			switch sig {
+			case syscall.SIGHUP:
+				a.Reload()
+			case syscall.SIGUSR1:
+				if err := a.ReopenLog(); err != nil {
+					fmt.Fprintln(os.Stderr, "reopen log:", err)
+				}
+			default:
+				a.Stop()
+				return
+			}
+		}
+	}()
+	if err := a.Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		pkg.Exit(1)
 	}
-	ch <- res
 }
 
-func main() {
-	go activeCheck()
-	res := <-ch
-	fmt.Println(res)
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [config dump]\n\n", os.Args[0])
+	fmt.Fprintln(flag.CommandLine.Output(), "Every config key can also be set with an environment variable,")
+	fmt.Fprintln(flag.CommandLine.Output(), "e.g. server.ip with ZBX_SERVER_IP. Flags win over the environment,")
+	fmt.Fprintln(flag.CommandLine.Output(), "which wins over the config file.")
+	fmt.Fprintln(flag.CommandLine.Output())
+	fmt.Fprintln(flag.CommandLine.Output(), "SIGHUP or -R config_reload reloads the config while running,")
+	fmt.Fprintln(flag.CommandLine.Output(), "SIGUSR1 reopens the log file after an external logrotate,")
+	fmt.Fprintln(flag.CommandLine.Output(), "-R log_level_increase[=component] and -R log_level_decrease[=component]")
+	fmt.Fprintln(flag.CommandLine.Output(), "change the log level until the next reload.")
+	fmt.Fprintln(flag.CommandLine.Output())
+	flag.PrintDefaults()
 }
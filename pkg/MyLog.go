@@ -10,15 +10,19 @@
 // of each logged message.
 // Every log message is output on a separate line: if the message being
 // printed does not end in a newline, the logger will add one.
-// The Fatal functions call os.Exit(1) after writing the log message.
+// The Fatal functions write the log message at the Fatal level and call
+// Exit(1), which runs the hooks registered with OnExit first.
 // The Panic functions call panic after writing the log message.
 package pkg
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -30,27 +34,139 @@ const (
 	Llongfile                 // full file name and line number: /a/b/c/d.go:23
 	Lshortfile                // final file name element and line number: d.go:23. overrides Llongfile
 	LUTC                      // if Ldate or Ltime is set, use UTC rather than the local time zone
+	Llevel                    // the level of the message: INFO
+	LstdFlags = Ldate | Ltime | Llongfile | Llevel // initial values for the standard logger
+
+)
+
+// Level is the severity of a message. Levels are ordered: a logger prints
+// the messages at its own level and above.
+type Level int
+
+const (
+	Ltrace Level = iota
 	Ldebug
 	Linfo
 	Lwarn
 	Lerror
 	Lpanic
-	Ltrace
-	LstdFlags = Ldate | Ltime | Llongfile // initial values for the standard logger
-
+	Lfatal
 )
 
+var levelNames = []string{
+	Ltrace: "TRACE",
+	Ldebug: "DEBUG",
+	Linfo:  "INFO",
+	Lwarn:  "WARN",
+	Lerror: "ERROR",
+	Lpanic: "PANIC",
+	Lfatal: "FATAL",
+}
+
+func (lv Level) String() string {
+	if lv >= 0 && int(lv) < len(levelNames) {
+		return levelNames[lv]
+	}
+	return "UNKNOWN"
+}
+
+// ParseLevel returns the level named s, ignoring case, e.g. "debug".
+func ParseLevel(s string) (Level, error) {
+	upper := strings.ToUpper(s)
+	if upper == "WARNING" {
+		upper = "WARN"
+	}
+	for i, name := range levelNames {
+		if name == upper {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// A Logger is shared by the children made with With and Named: they all
+// write through the same core, and only add their own fields to each
+// record. A named logger has its own level, see Named.
 type Logger struct {
-	mu     sync.Mutex // ensures atomic writes; protects the following fields
-	prefix string     // prefix to write at beginning of each line
-	flag   int        // properties
-	out    io.Writer  // destination for output
+	*core
+	name   string        // component name, empty for the standard logger
+	fields []interface{} // key/value pairs added to every record, never modified
+}
+
+type core struct {
+	mu     sync.Mutex       // ensures atomic writes; protects the following fields
+	prefix string           // prefix to write at beginning of each line
+	flag   int              // properties
+	level  Level            // messages below this level are dropped
+	levels map[string]Level // level of the named loggers, by name
+	format Format           // text or JSON records
+	filter filter           // repeat suppression and rate limits
+	out    io.Writer        // destination for output
 	buf    []byte
 }
 
+// levelOf returns the level of the logger called name: its own, else the
+// one of the component before the first ':' ("collector" for
+// "collector:vfs.fs.size"), else the level of the core. l.mu is held.
+func (c *core) levelOf(name string) Level {
+	if name == "" {
+		return c.level
+	}
+	if level, ok := c.levels[name]; ok {
+		return level
+	}
+	if i := strings.IndexByte(name, ':'); i > 0 {
+		if level, ok := c.levels[name[:i]]; ok {
+			return level
+		}
+	}
+	return c.level
+}
+
 
 func New(out io.Writer, prefix string, flag int) *Logger {
-	return &Logger{out: out, prefix: prefix, flag: flag}
+	return &Logger{core: &core{out: out, prefix: prefix, flag: flag, level: Linfo}}
+}
+
+// Fields are key/value pairs attached to log records.
+type Fields map[string]interface{}
+
+// With returns a child logger adding the key/value pairs in keyvals to
+// every record, after the fields of l. The child shares the output,
+// level, flags and format of l.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	if len(keyvals)%2 == 1 {
+		keyvals = append(keyvals[:len(keyvals)-1:len(keyvals)-1], "!BADKEY", keyvals[len(keyvals)-1])
+	}
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	return &Logger{core: l.core, name: l.name, fields: fields}
+}
+
+// Named returns a logger for the component name, e.g. "sender" or
+// "collector:vfs.fs.size", writing to the output of l with a component
+// field. Its level is set with SetLevel or SetLevels; until then it
+// follows the level of the component before the first ':' and then the
+// level of l.
+func (l *Logger) Named(name string) *Logger {
+	n := l.With("component", name)
+	n.name = name
+	return n
+}
+
+// WithFields is like With, with the fields added in key order.
+func (l *Logger) WithFields(fields Fields) *Logger {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	keyvals := make([]interface{}, 0, 2*len(keys))
+	for _, k := range keys {
+		keyvals = append(keyvals, k, fields[k])
+	}
+	return l.With(keyvals...)
 }
 
 var std = New(os.Stderr, "", LstdFlags)
@@ -75,8 +191,9 @@ func itoa(buf *[]byte, i int, wid int) {
 // formatHeader writes log header to buf in following order:
 //   * l.prefix (if it's not blank),
 //   * date and/or time (if corresponding flags are provided),
+//   * level (if Llevel is provided),
 //   * file and line number (if corresponding flags are provided).
-func (l *Logger) formatHeader(buf *[]byte, t time.Time, file string, line int, level int) {
+func (l *Logger) formatHeader(buf *[]byte, t time.Time, file string, line int, level Level) {
 	*buf = append(*buf, l.prefix...)
 	if l.flag&(Ldate|Ltime|Lmicroseconds) != 0 {
 		if l.flag&LUTC != 0 {
@@ -106,23 +223,9 @@ func (l *Logger) formatHeader(buf *[]byte, t time.Time, file string, line int, l
 		}
 	}
 
-	if l.flag&(Ldebug|Linfo|Lwarn|Lerror|Lpanic) != 0 {
-		switch level {
-		case Ldebug:
-			*buf = append(*buf, []byte(" DEBUG ")...)
-		case Linfo:
-			*buf = append(*buf, []byte(" INFO ")...)
-		case Lwarn:
-			*buf = append(*buf, []byte(" WARN ")...)
-		case Lerror:
-			*buf = append(*buf, []byte(" ERROR ")...)
-		case Lpanic:
-			*buf = append(*buf, []byte(" PANIC ")...)
-		case Ltrace:
-			*buf = append(*buf, []byte(" TRACE ")...)
-		default:
-			*buf = append(*buf, []byte(" UNKNOWN ")...)
-		}
+	if l.flag&Llevel != 0 {
+		*buf = append(*buf, level.String()...)
+		*buf = append(*buf, ' ')
 	}
 
 	if l.flag&(Lshortfile|Llongfile) != 0 {
@@ -143,41 +246,73 @@ func (l *Logger) formatHeader(buf *[]byte, t time.Time, file string, line int, l
 	}
 }
 
-func (l *Logger) Output(calldepth int, level int, s string) error {
+func (l *Logger) Output(calldepth int, level Level, s string) error {
+	return l.output(calldepth+1, level, s, nil)
+}
+
+// Log writes msg at level followed by the key/value pairs in keyvals,
+// e.g. l.Log(Lwarn, "send failed", "server", addr, "values", n).
+func (l *Logger) Log(level Level, msg string, keyvals ...interface{}) error {
+	return l.output(2, level, msg, keyvals)
+}
+
+func (l *Logger) output(calldepth int, level Level, s string, keyvals []interface{}) error {
 	now := time.Now() // get this early.
+	var pc uintptr
 	var file string
 	var line int
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	if l.flag&(Ldebug|Linfo|Lwarn|Lerror|Lpanic|Ltrace) != 0 {
-		if l.flag&Linfo != 0 && level < Linfo {
-			return nil
-		} else if l.flag&Lwarn != 0 && level < Lwarn {
-			return nil
-		} else if l.flag&Lerror != 0 && level < Lerror {
-			return nil
-		} else if l.flag&Lpanic != 0 && level < Lpanic {
-			return nil
-		} else if l.flag&Ltrace != 0 && level < Ltrace {
-			return nil
-		}
+	if level < l.levelOf(l.name) {
+		return nil
 	}
-	if l.flag&(Lshortfile|Llongfile) != 0 {
+	filtered := l.filter.enabled()
+	if filtered || l.flag&(Lshortfile|Llongfile) != 0 {
 		// Release lock while getting caller info - it's expensive.
 		l.mu.Unlock()
 		var ok bool
-		_, file, line, ok = runtime.Caller(calldepth)
+		pc, file, line, ok = runtime.Caller(calldepth)
 		if !ok {
 			file = "???"
 			line = 0
 		}
 		l.mu.Lock()
 	}
+	if filtered {
+		ok, sums := l.check(pc, file, line, now, level, s, keyvals, l.fields)
+		for _, sum := range sums {
+			l.write(now, file, line, sum.level, sum.msg, sum.keyvals)
+		}
+		if !ok {
+			return nil
+		}
+	}
+	if len(l.fields) > 0 {
+		keyvals = append(l.fields[:len(l.fields):len(l.fields)], keyvals...)
+	}
+	return l.write(now, file, line, level, s, keyvals)
+}
+
+// write formats one record and writes it to the output. l.mu is held.
+func (l *Logger) write(now time.Time, file string, line int, level Level, s string,
+	keyvals []interface{}) error {
 	l.buf = l.buf[:0]
-	l.formatHeader(&l.buf, now, file, line, level)
-	l.buf = append(l.buf, s...)
-	if len(s) == 0 || s[len(s)-1] != '\n' {
-		l.buf = append(l.buf, '\n')
+	if l.format == FormatJSON {
+		l.formatJSON(&l.buf, now, file, line, level, s, keyvals)
+	} else {
+		l.formatHeader(&l.buf, now, file, line, level)
+		l.buf = append(l.buf, s...)
+		if len(keyvals) > 0 {
+			l.buf = bytes.TrimRight(l.buf, "\n")
+			appendTextFields(&l.buf, keyvals)
+		}
+		if len(l.buf) == 0 || l.buf[len(l.buf)-1] != '\n' {
+			l.buf = append(l.buf, '\n')
+		}
+	}
+	if lw, ok := l.out.(LevelWriter); ok {
+		_, err := lw.WriteLevel(level, l.buf)
+		return err
 	}
 	_, err := l.out.Write(l.buf)
 	return err
@@ -197,22 +332,22 @@ func (l *Logger) Print(v ...interface{}) { l.Output(2, Linfo, fmt.Sprint(v...))
 // Arguments are handled in the manner of fmt.Println.
 func (l *Logger) Println(v ...interface{}) { l.Output(2, Linfo, fmt.Sprintln(v...)) }
 
-// Fatal is equivalent to l.Print() followed by a call to os.Exit(1).
+// Fatal is equivalent to l.Print() followed by a call to Exit(1).
 func (l *Logger) Fatal(v ...interface{}) {
-	l.Output(2, Lerror, fmt.Sprint(v...))
-	os.Exit(1)
+	l.Output(2, Lfatal, fmt.Sprint(v...))
+	Exit(1)
 }
 
-// Fatalf is equivalent to l.Printf() followed by a call to os.Exit(1).
+// Fatalf is equivalent to l.Printf() followed by a call to Exit(1).
 func (l *Logger) Fatalf(format string, v ...interface{}) {
-	l.Output(2, Lerror, fmt.Sprintf(format, v...))
-	os.Exit(1)
+	l.Output(2, Lfatal, fmt.Sprintf(format, v...))
+	Exit(1)
 }
 
-// Fatalln is equivalent to l.Println() followed by a call to os.Exit(1).
+// Fatalln is equivalent to l.Println() followed by a call to Exit(1).
 func (l *Logger) Fatalln(v ...interface{}) {
-	l.Output(2, Lerror, fmt.Sprintln(v...))
-	os.Exit(1)
+	l.Output(2, Lfatal, fmt.Sprintln(v...))
+	Exit(1)
 }
 
 // Panic is equivalent to l.Print() followed by a call to panic().
@@ -264,6 +399,53 @@ func (l *Logger) SetPrefix(prefix string) {
 	l.prefix = prefix
 }
 
+// Level returns the minimum level of the messages printed by the logger.
+func (l *Logger) Level() Level {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.levelOf(l.name)
+}
+
+// SetLevel sets the minimum level of the messages printed by the logger.
+// For a named logger this is the level of its component only.
+func (l *Logger) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.name == "" {
+		l.level = level
+		return
+	}
+	if l.levels == nil {
+		l.levels = make(map[string]Level)
+	}
+	l.levels[l.name] = level
+}
+
+// SetLevels replaces the levels of all the named loggers sharing the
+// output of l. Components left out follow the level of l again.
+func (l *Logger) SetLevels(levels map[string]Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.levels = make(map[string]Level, len(levels))
+	for name, level := range levels {
+		l.levels[name] = level
+	}
+}
+
+// Format returns the record format of the logger.
+func (l *Logger) Format() Format {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.format
+}
+
+// SetFormat sets the record format of the logger.
+func (l *Logger) SetFormat(format Format) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.format = format
+}
+
 // SetOutput sets the output destination for the standard logger.
 func SetOutput(w io.Writer) {
 	std.mu.Lock()
@@ -281,6 +463,27 @@ func SetFlags(flag int) {
 	std.SetFlags(flag)
 }
 
+// GetLevel returns the minimum level printed by the standard logger.
+func GetLevel() Level {
+	return std.Level()
+}
+
+// SetLevel sets the minimum level printed by the standard logger.
+func SetLevel(level Level) {
+	std.SetLevel(level)
+}
+
+// SetLevels replaces the levels of the named loggers of the standard
+// logger.
+func SetLevels(levels map[string]Level) {
+	std.SetLevels(levels)
+}
+
+// SetFormat sets the record format of the standard logger.
+func SetFormat(format Format) {
+	std.SetFormat(format)
+}
+
 // Prefix returns the output prefix for the standard logger.
 func Prefix() string {
 	return std.Prefix()
@@ -311,22 +514,22 @@ func Println(v ...interface{}) {
 	std.Output(2, Linfo, fmt.Sprintln(v...))
 }
 
-// Fatal is equivalent to Print() followed by a call to os.Exit(1).
+// Fatal is equivalent to Print() followed by a call to Exit(1).
 func Fatal(v ...interface{}) {
-	std.Output(2, Lerror, fmt.Sprint(v...))
-	os.Exit(1)
+	std.Output(2, Lfatal, fmt.Sprint(v...))
+	Exit(1)
 }
 
-// Fatalf is equivalent to Printf() followed by a call to os.Exit(1).
+// Fatalf is equivalent to Printf() followed by a call to Exit(1).
 func Fatalf(format string, v ...interface{}) {
-	std.Output(2, Lerror, fmt.Sprintf(format, v...))
-	os.Exit(1)
+	std.Output(2, Lfatal, fmt.Sprintf(format, v...))
+	Exit(1)
 }
 
-// Fatalln is equivalent to Println() followed by a call to os.Exit(1).
+// Fatalln is equivalent to Println() followed by a call to Exit(1).
 func Fatalln(v ...interface{}) {
-	std.Output(2, Lerror, fmt.Sprintln(v...))
-	os.Exit(1)
+	std.Output(2, Lfatal, fmt.Sprintln(v...))
+	Exit(1)
 }
 
 // Debug calls Output to print to the standard logger.
@@ -537,6 +740,30 @@ func Panicln(v ...interface{}) {
 // frames to skip when computing the file name and line number
 // if Llongfile or Lshortfile is set; a value of 1 will print the details
 // for the caller of Output.
-func Output(calldepth int, level int, s string) error {
+func Output(calldepth int, level Level, s string) error {
 	return std.Output(calldepth+1, level, s) // +1 for this frame.
+}
+
+// With returns a child of the standard logger adding keyvals to every
+// record.
+func With(keyvals ...interface{}) *Logger {
+	return std.With(keyvals...)
+}
+
+// Named returns the logger of the component name, writing to the output
+// of the standard logger.
+func Named(name string) *Logger {
+	return std.Named(name)
+}
+
+// WithFields returns a child of the standard logger adding fields to every
+// record.
+func WithFields(fields Fields) *Logger {
+	return std.WithFields(fields)
+}
+
+// Log writes msg at level followed by the key/value pairs in keyvals to
+// the standard logger.
+func Log(level Level, msg string, keyvals ...interface{}) error {
+	return std.output(2, level, msg, keyvals)
 }
\ No newline at end of file
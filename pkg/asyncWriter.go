@@ -0,0 +1,213 @@
+/*******************************************************************************
+* FileName:  asyncWriter.go
+* Author: Victor
+* Date: 2019/09/01 10:30
+* Description: asynchronous log output with a bounded queue
+* Project: zabbix_agent
+*******************************************************************************/
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy decides what an AsyncWriter does with a record when its queue
+// is full.
+type DropPolicy int
+
+const (
+	DropOldest DropPolicy = iota // discard the oldest queued record
+	DropNewest                   // discard the new record
+	DropNone                     // block the caller until there is room
+)
+
+var dropPolicyNames = []string{
+	DropOldest: "oldest",
+	DropNewest: "newest",
+	DropNone:   "block",
+}
+
+func (p DropPolicy) String() string {
+	if p >= 0 && int(p) < len(dropPolicyNames) {
+		return dropPolicyNames[p]
+	}
+	return "unknown"
+}
+
+// ParseDropPolicy returns the policy named s: "oldest", "newest" or
+// "block".
+func ParseDropPolicy(s string) (DropPolicy, error) {
+	for i, name := range dropPolicyNames {
+		if name == strings.ToLower(s) {
+			return DropPolicy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown drop policy %q", s)
+}
+
+// ErrClosed is returned by writes to a closed AsyncWriter.
+var ErrClosed = errors.New("log writer closed")
+
+// dropped counts the records dropped by every AsyncWriter.
+var dropped uint64
+
+// DroppedRecords returns the number of log records dropped so far by all
+// the asynchronous writers because their queue was full.
+func DroppedRecords() uint64 {
+	return atomic.LoadUint64(&dropped)
+}
+
+// record is one queued write. plain records came through Write and are
+// written with Write, the others with WriteLevel.
+type record struct {
+	level Level
+	plain bool
+	p     []byte
+}
+
+// AsyncWriter queues records in a ring buffer and writes them to out from
+// its own goroutine, so a slow disk does not hold up the callers of the
+// logger. Write errors of out cannot be reported and are lost.
+type AsyncWriter struct {
+	out    io.Writer
+	policy DropPolicy
+
+	mu      sync.Mutex
+	cond    *sync.Cond // signals a change of n, busy or closed
+	ring    []record
+	head    int    // index of the oldest record
+	n       int    // number of queued records
+	spare   []byte // buffer of the record last written, reused
+	busy    bool   // a record is being written
+	closed  bool
+	dropped uint64
+	done    chan struct{}
+}
+
+// NewAsyncWriter returns a writer queueing up to size records for out,
+// applying policy when the queue is full.
+func NewAsyncWriter(out io.Writer, size int, policy DropPolicy) *AsyncWriter {
+	if size < 1 {
+		size = 1
+	}
+	w := &AsyncWriter{
+		out:    out,
+		policy: policy,
+		ring:   make([]record, size),
+		done:   make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+// Write queues p.
+func (w *AsyncWriter) Write(p []byte) (int, error) {
+	return w.enqueue(Linfo, true, p)
+}
+
+// WriteLevel queues p, to be written with the WriteLevel method of out if
+// it has one.
+func (w *AsyncWriter) WriteLevel(level Level, p []byte) (int, error) {
+	return w.enqueue(level, false, p)
+}
+
+func (w *AsyncWriter) enqueue(level Level, plain bool, p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.n == len(w.ring) && !w.closed {
+		switch w.policy {
+		case DropNewest:
+			w.drop()
+			return len(p), nil
+		case DropOldest:
+			w.head = (w.head + 1) % len(w.ring)
+			w.n--
+			w.drop()
+		default:
+			w.cond.Wait()
+		}
+	}
+	if w.closed {
+		return 0, ErrClosed
+	}
+	r := &w.ring[(w.head+w.n)%len(w.ring)]
+	r.level, r.plain, r.p = level, plain, append(r.p[:0], p...)
+	w.n++
+	w.cond.Broadcast()
+	return len(p), nil
+}
+
+// drop counts one dropped record. w.mu is held.
+func (w *AsyncWriter) drop() {
+	w.dropped++
+	atomic.AddUint64(&dropped, 1)
+}
+
+// run writes the queued records until the writer is closed and the queue
+// is empty.
+func (w *AsyncWriter) run() {
+	defer close(w.done)
+	lw, leveled := w.out.(LevelWriter)
+	w.mu.Lock()
+	for {
+		for w.n == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if w.n == 0 {
+			w.mu.Unlock()
+			return
+		}
+		r := w.ring[w.head]
+		w.ring[w.head].p = w.spare
+		w.head = (w.head + 1) % len(w.ring)
+		w.n--
+		w.busy = true
+		w.cond.Broadcast()
+		w.mu.Unlock()
+
+		if leveled && !r.plain {
+			lw.WriteLevel(r.level, r.p)
+		} else {
+			w.out.Write(r.p)
+		}
+
+		w.mu.Lock()
+		w.spare = r.p[:0]
+		w.busy = false
+		w.cond.Broadcast()
+	}
+}
+
+// Dropped returns the number of records this writer dropped.
+func (w *AsyncWriter) Dropped() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dropped
+}
+
+// Flush waits until every record queued so far has been written.
+func (w *AsyncWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.n > 0 || w.busy {
+		w.cond.Wait()
+	}
+	return nil
+}
+
+// Close writes the queued records and stops the writer. Later writes fail
+// with ErrClosed. The underlying writer is left open.
+func (w *AsyncWriter) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	<-w.done
+	return nil
+}
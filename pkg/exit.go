@@ -0,0 +1,77 @@
+/*******************************************************************************
+* FileName:  exit.go
+* Author: Victor
+* Date: 2019/09/02 09:15
+* Description: shutdown hooks run before the process exits
+* Project: zabbix_agent
+*******************************************************************************/
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ExitTimeout bounds the time Exit waits for the exit hooks, so a hook
+// stuck on a dead disk or server cannot keep the process alive.
+var ExitTimeout = 10 * time.Second
+
+var (
+	exitMu    sync.Mutex
+	exitHooks []func()
+	exiting   int32 // set by the first Exit
+)
+
+// OnExit registers fn to run before the process exits through Exit or a
+// Fatal function, e.g. to flush buffers or close listeners. Hooks run in
+// the reverse order of registration, like deferred calls.
+func OnExit(fn func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	exitHooks = append(exitHooks, fn)
+}
+
+// Exit runs the exit hooks, writes what the standard logger still holds
+// and calls os.Exit(code). A hook that panics does not stop the others; a
+// second Exit, e.g. from a hook, exits at once.
+func Exit(code int) {
+	if !atomic.CompareAndSwapInt32(&exiting, 0, 1) {
+		os.Exit(code)
+	}
+	done := make(chan struct{})
+	go func() {
+		runExitHooks()
+		std.FlushRepeats()
+		std.mu.Lock()
+		if f, ok := std.out.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+		std.mu.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(ExitTimeout):
+		fmt.Fprintf(os.Stderr, "exit hooks still running after %v, exiting anyway\n", ExitTimeout)
+	}
+	os.Exit(code)
+}
+
+func runExitHooks() {
+	exitMu.Lock()
+	hooks := append([]func(){}, exitHooks...)
+	exitMu.Unlock()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Fprintf(os.Stderr, "exit hook panicked: %v\n", r)
+				}
+			}()
+			hooks[i]()
+		}()
+	}
+}
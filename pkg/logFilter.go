@@ -0,0 +1,291 @@
+/*******************************************************************************
+* FileName:  logFilter.go
+* Author: Victor
+* Date: 2019/09/01 16:20
+* Description: suppression of repeated log records and per call site rate limits
+* Project: zabbix_agent
+*******************************************************************************/
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// filter holds the repeat and rate limit state of a core, by call site.
+//
+// A record is a repeat of a recent record from its call site if it has
+// the same level, message and key/value pairs given to Log; the fields
+// added with With are context and not compared, so the same error for the
+// next batch still counts. A call site remembers its last maxSiteRecords
+// distinct records, so one logging the failures of several items in turn
+// is suppressed per item. Repeats within the window are counted instead
+// of written, and the count is written as `last message "msg" repeated N
+// times` when the window of the record ends.
+//
+// With a rate limit a call site writes at most rate records per second,
+// in bursts of up to rate. The records over the limit are counted and the
+// count is written with the next record let through, or after a second.
+type filter struct {
+	window time.Duration // 0 disables repeat suppression
+	rate   int           // 0 disables the rate limit
+	sites  map[uintptr]*site
+}
+
+// maxSiteRecords bounds the distinct records a call site remembers; the
+// oldest is forgotten, and its count written, for a new one.
+const maxSiteRecords = 16
+
+// site is the state of one call site.
+type site struct {
+	file string
+	line int
+
+	records map[recordKey]*recent // up to maxSiteRecords
+	timer   *time.Timer           // writes the counts when they are due
+	due     time.Time             // when timer fires
+
+	tokens  float64
+	last    time.Time // last refill of tokens
+	limited int       // records dropped by the rate limit
+}
+
+// recordKey tells the records of a call site apart.
+type recordKey struct {
+	level Level
+	text  string // message and key/value pairs
+}
+
+// recent is the repeat state of one record of a call site.
+type recent struct {
+	msg      string
+	keyvals  []interface{}
+	since    time.Time     // when it was last written
+	repeated int           // repeats suppressed since
+	fields   []interface{} // context of the last repeat
+}
+
+func (f *filter) enabled() bool {
+	return f.window > 0 || f.rate > 0
+}
+
+// check reports whether the record from the call site pc is written, and
+// returns the counts of suppressed records to write before it. l.mu is
+// held.
+func (c *core) check(pc uintptr, file string, line int, now time.Time, level Level, msg string,
+	keyvals, fields []interface{}) (bool, []summary) {
+	f := &c.filter
+	if f.sites == nil {
+		f.sites = make(map[uintptr]*site)
+	}
+	s, ok := f.sites[pc]
+	if !ok {
+		s = &site{file: file, line: line, records: make(map[recordKey]*recent), tokens: float64(f.rate), last: now}
+		f.sites[pc] = s
+	}
+	key := recordKey{level, msg + sprintKeyvals(keyvals)}
+	if r := s.records[key]; f.window > 0 && r != nil && now.Sub(r.since) < f.window {
+		r.repeated++
+		r.fields = fields
+		c.schedule(s, now)
+		return false, nil
+	}
+	if f.rate > 0 {
+		s.tokens += now.Sub(s.last).Seconds() * float64(f.rate)
+		if s.tokens > float64(f.rate) {
+			s.tokens = float64(f.rate)
+		}
+		s.last = now
+		if s.tokens < 1 {
+			s.limited++
+			c.schedule(s, now)
+			return false, nil
+		}
+		s.tokens--
+	}
+	sums := s.expired(now, f.window)
+	if f.window > 0 {
+		if len(s.records) >= maxSiteRecords {
+			sums = append(sums, s.forgetOldest()...)
+		}
+		s.records[key] = &recent{msg: msg, keyvals: keyvals, since: now}
+	}
+	sums = append(sums, s.dropped()...)
+	c.schedule(s, now)
+	return true, sums
+}
+
+// summary is a count of suppressed records, written as a record of its
+// own.
+type summary struct {
+	level   Level
+	msg     string
+	keyvals []interface{}
+}
+
+// summary returns the count of repeats of r, with the context of the last
+// one, and resets it.
+func (r *recent) summary(level Level) summary {
+	keyvals := append(r.fields[:len(r.fields):len(r.fields)], r.keyvals...)
+	keyvals = append(keyvals, "repeated", r.repeated)
+	sum := summary{level, fmt.Sprintf("last message %q repeated %d times", r.msg, r.repeated), keyvals}
+	r.repeated = 0
+	return sum
+}
+
+// sortedRecords returns the keys of the records of s, oldest first.
+func (s *site) sortedRecords() []recordKey {
+	keys := make([]recordKey, 0, len(s.records))
+	for k := range s.records {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return s.records[keys[i]].since.Before(s.records[keys[j]].since) })
+	return keys
+}
+
+// expired forgets the records whose window has ended and returns their
+// counts.
+func (s *site) expired(now time.Time, window time.Duration) []summary {
+	var sums []summary
+	for _, k := range s.sortedRecords() {
+		r := s.records[k]
+		if now.Sub(r.since) < window {
+			continue
+		}
+		if r.repeated > 0 {
+			sums = append(sums, r.summary(k.level))
+		}
+		delete(s.records, k)
+	}
+	return sums
+}
+
+// forgetOldest forgets the oldest record and returns its count.
+func (s *site) forgetOldest() []summary {
+	keys := s.sortedRecords()
+	if len(keys) == 0 {
+		return nil
+	}
+	r := s.records[keys[0]]
+	delete(s.records, keys[0])
+	if r.repeated == 0 {
+		return nil
+	}
+	return []summary{r.summary(keys[0].level)}
+}
+
+// dropped returns the count of records dropped by the rate limit and
+// resets it.
+func (s *site) dropped() []summary {
+	if s.limited == 0 {
+		return nil
+	}
+	sum := summary{Lwarn, fmt.Sprintf("%d messages dropped by the rate limit", s.limited),
+		[]interface{}{"dropped", s.limited}}
+	s.limited = 0
+	return []summary{sum}
+}
+
+// schedule makes the timer of s write its counts when the first of them
+// is due: at the end of the window of a repeated record, or a second after
+// the rate limit dropped a record.
+func (c *core) schedule(s *site, now time.Time) {
+	var due time.Time
+	for _, r := range s.records {
+		if t := r.since.Add(c.filter.window); r.repeated > 0 && (due.IsZero() || t.Before(due)) {
+			due = t
+		}
+	}
+	if t := s.last.Add(time.Second); s.limited > 0 && (due.IsZero() || t.Before(due)) {
+		due = t
+	}
+	if due.IsZero() || (s.timer != nil && !due.Before(s.due)) {
+		return
+	}
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.due = due
+	s.timer = time.AfterFunc(due.Sub(now), func() { c.flushSite(s) })
+}
+
+// flushSite writes the counts of s that are due.
+func (c *core) flushSite(s *site) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.timer = nil
+	now := time.Now()
+	sums := s.expired(now, c.filter.window)
+	if now.Sub(s.last) >= time.Second {
+		sums = append(sums, s.dropped()...)
+	}
+	l := &Logger{core: c}
+	for _, sum := range sums {
+		l.write(now, s.file, s.line, sum.level, sum.msg, sum.keyvals)
+	}
+	c.schedule(s, now)
+}
+
+// FlushRepeats writes the counts of suppressed records still pending,
+// e.g. before the output is closed.
+func (l *Logger) FlushRepeats() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for _, s := range l.filter.sites {
+		var sums []summary
+		for _, k := range s.sortedRecords() {
+			if r := s.records[k]; r.repeated > 0 {
+				sums = append(sums, r.summary(k.level))
+			}
+		}
+		for _, sum := range append(sums, s.dropped()...) {
+			l.write(now, s.file, s.line, sum.level, sum.msg, sum.keyvals)
+		}
+	}
+}
+
+// sprintKeyvals renders keyvals for comparing records.
+func sprintKeyvals(keyvals []interface{}) string {
+	if len(keyvals) == 0 {
+		return ""
+	}
+	var buf []byte
+	appendTextFields(&buf, keyvals)
+	return string(buf)
+}
+
+// SetRepeatWindow makes the logger count identical records from a call
+// site within d instead of writing them; 0 writes every record.
+func (l *Logger) SetRepeatWindow(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.filter.window = d
+}
+
+// SetRateLimit limits every call site to perSecond records per second;
+// 0 removes the limit.
+func (l *Logger) SetRateLimit(perSecond int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.filter.rate = perSecond
+	for _, s := range l.filter.sites {
+		s.tokens = float64(perSecond)
+	}
+}
+
+// SetRepeatWindow sets the repeat window of the standard logger.
+func SetRepeatWindow(d time.Duration) {
+	std.SetRepeatWindow(d)
+}
+
+// SetRateLimit sets the per call site rate limit of the standard logger.
+func SetRateLimit(perSecond int) {
+	std.SetRateLimit(perSecond)
+}
+
+// FlushRepeats writes the pending counts of the standard logger.
+func FlushRepeats() {
+	std.FlushRepeats()
+}
@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer written by the timers of the filter too.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return strings.Split(strings.TrimSuffix(b.buf.String(), "\n"), "\n")
+}
+
+func checkLines(t *testing.T, got, want []string) {
+	t.Helper()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// One call site alternating between records, as the collect failures of
+// several items, has the repeats of each suppressed.
+func TestRepeatInterleaved(t *testing.T) {
+	var out syncBuffer
+	l := New(&out, "", 0)
+	l.SetRepeatWindow(time.Hour)
+	for i := 0; i < 100; i++ {
+		item := "a"
+		if i%2 == 1 {
+			item = "b"
+		}
+		l.With("batch", i).Log(Lwarn, "collect failed", "item", item)
+	}
+	l.FlushRepeats()
+	checkLines(t, out.lines(), []string{
+		"collect failed batch=0 item=a",
+		"collect failed batch=1 item=b",
+		`last message "collect failed" repeated 49 times batch=98 item=a repeated=49`,
+		`last message "collect failed" repeated 49 times batch=99 item=b repeated=49`,
+	})
+}
+
+// A record of another level or with other pairs is not a repeat, and the
+// records of other call sites are counted apart.
+func TestRepeatDistinct(t *testing.T) {
+	var out syncBuffer
+	l := New(&out, "", 0)
+	l.SetRepeatWindow(time.Hour)
+	for i := 0; i < 2; i++ {
+		l.Log(Lwarn, "slow")
+		l.Log(Lerror, "slow")
+		l.Log(Lwarn, "slow", "ms", 10)
+	}
+	l.Log(Lwarn, "slow")
+	l.FlushRepeats()
+	lines := out.lines()
+	sort.Strings(lines[4:]) // the call sites are flushed in no order
+	checkLines(t, lines, []string{
+		"slow",
+		"slow",
+		"slow ms=10",
+		"slow",
+		`last message "slow" repeated 1 times ms=10 repeated=1`,
+		`last message "slow" repeated 1 times repeated=1`,
+		`last message "slow" repeated 1 times repeated=1`,
+	})
+}
+
+// A call site remembers maxSiteRecords records: the oldest is forgotten
+// for a new one, its count written first.
+func TestRepeatBounded(t *testing.T) {
+	var out syncBuffer
+	l := New(&out, "", 0)
+	l.SetRepeatWindow(time.Hour)
+	items := []int{0, 0}
+	for i := 1; i <= maxSiteRecords; i++ {
+		items = append(items, i)
+	}
+	for _, item := range append(items, 0) {
+		l.Log(Lwarn, "collect failed", "item", item)
+	}
+	lines := out.lines()
+	if len(lines) != maxSiteRecords+3 {
+		t.Fatalf("got %d lines:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	checkLines(t, lines[maxSiteRecords:], []string{
+		`last message "collect failed" repeated 1 times item=0 repeated=1`,
+		fmt.Sprintf("collect failed item=%d", maxSiteRecords),
+		"collect failed item=0",
+	})
+}
+
+// The count of a record is written when its window ends, and a record
+// after the window is written again.
+func TestRepeatWindow(t *testing.T) {
+	var out syncBuffer
+	l := New(&out, "", 0)
+	l.SetRepeatWindow(50 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		if i == 3 {
+			time.Sleep(150 * time.Millisecond)
+			checkLines(t, out.lines(), []string{
+				"send failed",
+				`last message "send failed" repeated 2 times repeated=2`,
+			})
+		}
+		l.Log(Lwarn, "send failed")
+	}
+	checkLines(t, out.lines()[2:], []string{"send failed"})
+}
+
+func TestRateLimit(t *testing.T) {
+	var out syncBuffer
+	l := New(&out, "", 0)
+	l.SetRateLimit(2)
+	for i := 0; i < 5; i++ {
+		l.Log(Linfo, "sent", "n", i)
+	}
+	time.Sleep(1200 * time.Millisecond)
+	checkLines(t, out.lines(), []string{
+		"sent n=0",
+		"sent n=1",
+		"3 messages dropped by the rate limit dropped=3",
+	})
+}
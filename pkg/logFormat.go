@@ -0,0 +1,200 @@
+/*******************************************************************************
+* FileName:  logFormat.go
+* Author: Victor
+* Date: 2019/08/30 10:05
+* Description: text and JSON record formats of the logger
+* Project: zabbix_agent
+*******************************************************************************/
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Format selects how a Logger writes its records.
+type Format int
+
+const (
+	FormatText Format = iota // header flags, message, then key=value fields
+	FormatJSON               // one JSON object per line
+)
+
+func (f Format) String() string {
+	if f == FormatJSON {
+		return "json"
+	}
+	return "text"
+}
+
+// ParseFormat returns the format named s: "text" or "json".
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "text", "":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return 0, fmt.Errorf("unknown log format %q", s)
+}
+
+// formatJSON writes one record as a JSON object. The header flags decide
+// which fields are present:
+//   - ts for Ldate, Ltime or Lmicroseconds, in RFC 3339 with microseconds
+//     if Lmicroseconds is set and in UTC if LUTC is set,
+//   - level for Llevel,
+//   - caller for Lshortfile or Llongfile,
+//
+// followed by prefix (if it's not blank), msg and the key/value fields.
+func (l *Logger) formatJSON(buf *[]byte, t time.Time, file string, line int, level Level, msg string,
+	keyvals []interface{}) {
+	*buf = append(*buf, '{')
+	sep := false
+	field := func(key string) {
+		if sep {
+			*buf = append(*buf, ',')
+		}
+		sep = true
+		appendJSONString(buf, key)
+		*buf = append(*buf, ':')
+	}
+	if l.flag&(Ldate|Ltime|Lmicroseconds) != 0 {
+		if l.flag&LUTC != 0 {
+			t = t.UTC()
+		}
+		layout := time.RFC3339
+		if l.flag&Lmicroseconds != 0 {
+			layout = "2006-01-02T15:04:05.000000Z07:00"
+		}
+		field("ts")
+		appendJSONString(buf, t.Format(layout))
+	}
+	if l.flag&Llevel != 0 {
+		field("level")
+		appendJSONString(buf, strings.ToLower(level.String()))
+	}
+	if l.flag&(Lshortfile|Llongfile) != 0 {
+		if l.flag&Lshortfile != 0 {
+			if i := strings.LastIndexByte(file, '/'); i >= 0 {
+				file = file[i+1:]
+			}
+		}
+		field("caller")
+		appendJSONString(buf, file+":"+strconv.Itoa(line))
+	}
+	if l.prefix != "" {
+		field("prefix")
+		appendJSONString(buf, strings.TrimSpace(l.prefix))
+	}
+	field("msg")
+	appendJSONString(buf, strings.TrimRight(msg, "\n"))
+	for i := 0; i < len(keyvals); i += 2 {
+		key, v := fieldPair(keyvals, i)
+		field(key)
+		appendJSONValue(buf, v)
+	}
+	*buf = append(*buf, '}', '\n')
+}
+
+// appendTextFields writes the key/value pairs as " key=value", quoting
+// values that contain spaces or quotes.
+func appendTextFields(buf *[]byte, keyvals []interface{}) {
+	for i := 0; i < len(keyvals); i += 2 {
+		key, v := fieldPair(keyvals, i)
+		*buf = append(*buf, ' ')
+		*buf = append(*buf, key...)
+		*buf = append(*buf, '=')
+		s := fieldString(v)
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			*buf = strconv.AppendQuote(*buf, s)
+		} else {
+			*buf = append(*buf, s...)
+		}
+	}
+}
+
+// fieldPair returns the pair starting at keyvals[i]. A key without a value
+// is reported under !BADKEY so it is not lost.
+func fieldPair(keyvals []interface{}, i int) (string, interface{}) {
+	if i+1 == len(keyvals) {
+		return "!BADKEY", keyvals[i]
+	}
+	key, ok := keyvals[i].(string)
+	if !ok {
+		key = fmt.Sprint(keyvals[i])
+	}
+	return key, keyvals[i+1]
+}
+
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
+
+func appendJSONValue(buf *[]byte, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		*buf = append(*buf, "null"...)
+	case string:
+		appendJSONString(buf, v)
+	case error:
+		appendJSONString(buf, v.Error())
+	case fmt.Stringer:
+		appendJSONString(buf, v.String())
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			appendJSONString(buf, fmt.Sprint(v))
+			return
+		}
+		*buf = append(*buf, b...)
+	}
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendJSONString writes s as a JSON string. Invalid UTF-8 is replaced by
+// U+FFFD.
+func appendJSONString(buf *[]byte, s string) {
+	*buf = append(*buf, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				*buf = append(*buf, '\\', c)
+			case c == '\n':
+				*buf = append(*buf, '\\', 'n')
+			case c == '\r':
+				*buf = append(*buf, '\\', 'r')
+			case c == '\t':
+				*buf = append(*buf, '\\', 't')
+			case c < 0x20:
+				*buf = append(*buf, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			default:
+				*buf = append(*buf, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			*buf = append(*buf, "\ufffd"...)
+		} else {
+			*buf = append(*buf, s[i:i+size]...)
+		}
+		i += size
+	}
+	*buf = append(*buf, '"')
+}
@@ -0,0 +1,220 @@
+/*******************************************************************************
+* FileName:  rotateWriter.go
+* Author: Victor
+* Date: 2019/08/29 09:40
+* Description: log file writer with size and time based rotation
+* Project: zabbix_agent
+*******************************************************************************/
+package pkg
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat names rotated files: agent.log.20190829-094012.
+const backupTimeFormat = "20060102-150405"
+
+// RotateWriter is an io.Writer appending to a log file. The file is
+// rotated when it grows beyond MaxSize or has been written for longer than
+// Interval; the rotated file is renamed with a timestamp suffix and
+// optionally gzipped. Old backups beyond MaxBackups or older than MaxAge
+// are removed. Zero disables the corresponding limit.
+//
+// The fields must be set before the first Write.
+type RotateWriter struct {
+	Filename   string
+	MaxSize    int64         // bytes
+	Interval   time.Duration // time between two rotations
+	MaxAge     time.Duration // age of the oldest backup kept
+	MaxBackups int
+	Compress   bool
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	millMu sync.Mutex // serializes compressing and removing backups
+}
+
+// Write appends p to the log file, rotating it first if it is due.
+func (w *RotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.due(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *RotateWriter) due(n int64) bool {
+	if w.MaxSize > 0 && w.size > 0 && w.size+n > w.MaxSize {
+		return true
+	}
+	return w.Interval > 0 && time.Since(w.opened) >= w.Interval
+}
+
+// open opens the log file for appending. w.mu must be held.
+func (w *RotateWriter) open() error {
+	f, err := os.OpenFile(w.Filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = fi.Size()
+	w.opened = time.Now()
+	return nil
+}
+
+// rotate renames the current file to a backup and starts a new one.
+// w.mu must be held.
+func (w *RotateWriter) rotate() error {
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	backup := w.Filename + "." + time.Now().Format(backupTimeFormat)
+	for i := 1; exists(backup) || exists(backup+".gz"); i++ {
+		backup = fmt.Sprintf("%s.%s.%d", w.Filename, time.Now().Format(backupTimeFormat), i)
+	}
+	if err := os.Rename(w.Filename, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	go w.mill(backup)
+	return nil
+}
+
+// Rotate rotates the log file now.
+func (w *RotateWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rotate()
+}
+
+// Reopen closes and reopens the log file without rotating it, for use
+// after an external tool such as logrotate moved it away.
+func (w *RotateWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	return w.open()
+}
+
+// Close closes the log file. A later Write opens it again.
+func (w *RotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// mill compresses the new backup if asked to and removes the backups that
+// are too many or too old. Errors go to standard error: the log file is
+// the thing that is failing.
+func (w *RotateWriter) mill(backup string) {
+	w.millMu.Lock()
+	defer w.millMu.Unlock()
+	if w.Compress {
+		if err := compressFile(backup); err != nil {
+			fmt.Fprintln(os.Stderr, "log rotate:", err)
+		}
+	}
+	backups, err := w.backups()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "log rotate:", err)
+		return
+	}
+	for i, b := range backups {
+		tooMany := w.MaxBackups > 0 && i >= w.MaxBackups
+		tooOld := w.MaxAge > 0 && time.Since(b.ModTime()) > w.MaxAge
+		if tooMany || tooOld {
+			os.Remove(filepath.Join(filepath.Dir(w.Filename), b.Name()))
+		}
+	}
+}
+
+// backups lists the rotated files, newest first.
+func (w *RotateWriter) backups() ([]os.FileInfo, error) {
+	infos, err := ioutil.ReadDir(filepath.Dir(w.Filename))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(w.Filename) + "."
+	var list []os.FileInfo
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() && strings.HasPrefix(fi.Name(), prefix) {
+			list = append(list, fi)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].ModTime().Equal(list[j].ModTime()) {
+			return list[i].ModTime().After(list[j].ModTime())
+		}
+		return list[i].Name() > list[j].Name()
+	})
+	return list, nil
+}
+
+func compressFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(name + ".gz")
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
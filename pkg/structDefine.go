@@ -25,20 +25,3 @@ type ResData struct {
 	Response string `json:"response"`
 	Info string `json:"info"`
 }
-
-type tomlConfig struct {
-	Server server `toml:"server"`
-	Agent agent `toml:"agent"`
-}
-
-type server struct {
-	Ip string
-	Port int `toml:"port"`
-	Version string `toml:"version"`
-}
-
-type agent struct {
-	Port int `toml:"port"`
-	LogLevel string `toml:"loglevel"`
-	Logfile string `toml:"logfile"`
-}
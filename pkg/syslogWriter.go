@@ -0,0 +1,202 @@
+/*******************************************************************************
+* FileName:  syslogWriter.go
+* Author: Victor
+* Date: 2019/08/31 09:50
+* Description: syslog (RFC 5424) and systemd journal log outputs
+* Project: zabbix_agent
+*******************************************************************************/
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LevelWriter is an output that needs the level of every record, such as
+// syslog. Logger calls WriteLevel instead of Write on outputs that
+// implement it.
+type LevelWriter interface {
+	WriteLevel(level Level, p []byte) (int, error)
+}
+
+// Default addresses of the local syslog daemon and of the systemd journal.
+const (
+	DefaultSyslogSocket  = "/dev/log"
+	DefaultJournalSocket = "/run/systemd/journal/socket"
+)
+
+// LogDaemon is the syslog facility used by SyslogWriter unless told
+// otherwise.
+const LogDaemon = 3
+
+// severity maps a level onto the syslog severity shared by syslog and the
+// journal: debug 7, info 6, warning 4, error 3, critical 2 and alert 1.
+func severity(level Level) int {
+	switch {
+	case level <= Ldebug:
+		return 7
+	case level == Linfo:
+		return 6
+	case level == Lwarn:
+		return 4
+	case level == Lerror:
+		return 3
+	case level == Lpanic:
+		return 2
+	}
+	return 1
+}
+
+// datagramWriter sends every record as one datagram on a unix socket and
+// reconnects once if the socket went away, e.g. after a syslog restart.
+type datagramWriter struct {
+	mu     sync.Mutex
+	socket string
+	conn   net.Conn
+}
+
+func (w *datagramWriter) send(msg []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	for try := 0; try < 2; try++ {
+		if w.conn == nil {
+			if w.conn, err = net.Dial("unixgram", w.socket); err != nil {
+				w.conn = nil
+				continue
+			}
+		}
+		if _, err = w.conn.Write(msg); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+// Close closes the socket. A later write connects again.
+func (w *datagramWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// SyslogWriter writes records to the local syslog daemon in the RFC 5424
+// format over a unix datagram socket.
+type SyslogWriter struct {
+	datagramWriter
+	facility int
+	tag      string
+	hostname string
+	pid      string
+}
+
+// NewSyslogWriter returns a writer to the syslog socket (DefaultSyslogSocket
+// if empty) logging as tag under facility. The socket is opened on the
+// first write.
+func NewSyslogWriter(socket, tag string, facility int) *SyslogWriter {
+	if socket == "" {
+		socket = DefaultSyslogSocket
+	}
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &SyslogWriter{
+		datagramWriter: datagramWriter{socket: socket},
+		facility:       facility,
+		tag:            tag,
+		hostname:       hostname,
+		pid:            strconv.Itoa(os.Getpid()),
+	}
+}
+
+// Write logs p at info level.
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(Linfo, p)
+}
+
+// WriteLevel logs p with the syslog severity of level.
+func (w *SyslogWriter) WriteLevel(level Level, p []byte) (int, error) {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "<%d>1 %s %s %s %s - - ",
+		w.facility*8+severity(level),
+		time.Now().Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, w.tag, w.pid)
+	msg.Write(bytes.TrimRight(p, "\n"))
+	if err := w.send(msg.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// JournalWriter writes records to the systemd journal using its native
+// datagram protocol.
+type JournalWriter struct {
+	datagramWriter
+	tag string
+}
+
+// NewJournalWriter returns a writer to the journal socket
+// (DefaultJournalSocket if empty) logging as tag. The socket is opened on
+// the first write.
+func NewJournalWriter(socket, tag string) *JournalWriter {
+	if socket == "" {
+		socket = DefaultJournalSocket
+	}
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+	return &JournalWriter{datagramWriter: datagramWriter{socket: socket}, tag: tag}
+}
+
+// Write logs p at info level.
+func (w *JournalWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(Linfo, p)
+}
+
+// WriteLevel logs p with the journal priority of level.
+func (w *JournalWriter) WriteLevel(level Level, p []byte) (int, error) {
+	var msg bytes.Buffer
+	journalField(&msg, "PRIORITY", []byte(strconv.Itoa(severity(level))))
+	journalField(&msg, "SYSLOG_IDENTIFIER", []byte(w.tag))
+	journalField(&msg, "MESSAGE", bytes.TrimRight(p, "\n"))
+	if err := w.send(msg.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// journalField appends one field. Values containing a newline use the
+// binary form: name, newline, 64 bit little endian length, value.
+func journalField(msg *bytes.Buffer, name string, value []byte) {
+	msg.WriteString(name)
+	if bytes.IndexByte(value, '\n') < 0 {
+		msg.WriteByte('=')
+		msg.Write(value)
+		msg.WriteByte('\n')
+		return
+	}
+	msg.WriteByte('\n')
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(len(value)))
+	msg.Write(n[:])
+	msg.Write(value)
+	msg.WriteByte('\n')
+}
@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// listenDatagrams listens on a unixgram socket in a temporary directory,
+// standing in for syslog or the journal. The directory is removed by
+// calling done.
+func listenDatagrams(t *testing.T) (conn *net.UnixConn, path string, done func()) {
+	dir, err := ioutil.TempDir("", "syslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path = filepath.Join(dir, "log.sock")
+	conn = listenAt(t, path)
+	return conn, path, func() {
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func listenAt(t *testing.T, path string) *net.UnixConn {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func readDatagram(t *testing.T, conn *net.UnixConn) []byte {
+	buf := make([]byte, 64*1024)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no datagram: %v", err)
+	}
+	return buf[:n]
+}
+
+func TestSeverity(t *testing.T) {
+	for level, want := range map[Level]int{
+		Ltrace: 7, Ldebug: 7, Linfo: 6, Lwarn: 4, Lerror: 3, Lpanic: 2, Lfatal: 1,
+	} {
+		if got := severity(level); got != want {
+			t.Errorf("severity(%v) = %d, want %d", level, got, want)
+		}
+	}
+}
+
+func TestSyslogWriter(t *testing.T) {
+	conn, path, done := listenDatagrams(t)
+	defer done()
+	w := NewSyslogWriter(path, "agent", LogDaemon)
+	defer w.Close()
+	header := `^<%s>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}(Z|[+-]\d\d:\d\d) \S+ agent \d+ - - `
+
+	tests := []struct {
+		write func() (int, error)
+		pri   string
+		msg   string
+	}{
+		{func() (int, error) { return w.WriteLevel(Lerror, []byte("disk full\n")) }, "27", "disk full"},
+		{func() (int, error) { return w.WriteLevel(Ldebug, []byte("tick")) }, "31", "tick"},
+		{func() (int, error) { return w.Write([]byte("started\n")) }, "30", "started"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.write(); err != nil {
+			t.Fatal(err)
+		}
+		got := readDatagram(t, conn)
+		re := regexp.MustCompile(fmt.Sprintf(header, tt.pri) + regexp.QuoteMeta(tt.msg) + "$")
+		if !re.Match(got) {
+			t.Errorf("datagram %q does not match %s", got, re)
+		}
+	}
+
+	w = NewSyslogWriter(path, "agent", 16) // local0
+	w.WriteLevel(Lwarn, []byte("x"))
+	if got := readDatagram(t, conn); !bytes.HasPrefix(got, []byte("<132>1 ")) {
+		t.Errorf("local0 warning: got %q, want priority 132", got)
+	}
+}
+
+func TestJournalWriter(t *testing.T) {
+	conn, path, done := listenDatagrams(t)
+	defer done()
+	w := NewJournalWriter(path, "agent")
+	defer w.Close()
+
+	if _, err := w.WriteLevel(Lwarn, []byte("slow send\n")); err != nil {
+		t.Fatal(err)
+	}
+	want := "PRIORITY=4\nSYSLOG_IDENTIFIER=agent\nMESSAGE=slow send\n"
+	if got := readDatagram(t, conn); string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// a message with a newline uses the binary field form
+	msg := "panic: boom\ngoroutine 1"
+	if _, err := w.WriteLevel(Lpanic, []byte(msg+"\n")); err != nil {
+		t.Fatal(err)
+	}
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], uint64(len(msg)))
+	want = "PRIORITY=2\nSYSLOG_IDENTIFIER=agent\nMESSAGE\n" + string(n[:]) + msg + "\n"
+	if got := readDatagram(t, conn); string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDatagramReconnect(t *testing.T) {
+	conn, path, done := listenDatagrams(t)
+	defer done()
+	w := NewSyslogWriter(path, "agent", LogDaemon)
+	defer w.Close()
+	if _, err := w.Write([]byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	readDatagram(t, conn)
+
+	// the daemon goes away: writes fail rather than block
+	conn.Close()
+	os.Remove(path)
+	if _, err := w.Write([]byte("lost")); err == nil {
+		t.Fatal("write without a listener succeeded")
+	}
+
+	// and comes back on the same path: the next write reconnects
+	conn = listenAt(t, path)
+	defer conn.Close()
+	if _, err := w.Write([]byte("two")); err != nil {
+		t.Fatalf("write after restart: %v", err)
+	}
+	if got := readDatagram(t, conn); !bytes.HasSuffix(got, []byte(" - - two")) {
+		t.Errorf("got %q after reconnecting", got)
+	}
+}
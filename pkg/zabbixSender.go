@@ -3,23 +3,39 @@ package pkg
 import (
 	"bytes"
 	"encoding/binary"
-	"fmt"
+	"errors"
 	"io"
-	"log"
 	"net"
-	"strconv"
+	"time"
 )
 
 
-func DataSender(data []byte) (string,error){
-	conf := Config()
-	address := conf.Server.Ip+":"+strconv.Itoa(conf.Server.Port)
-	fmt.Println(address)
-	// ,conf.Server_ip+":"+string(conf.Server_port))
-	conn,err := net.Dial("tcp",address)
+// DataSender sends data to the zabbix server at address (host:port) and
+// returns the server response. Connecting, sending and reading the response
+// must all be done within timeout, so a server that accepts but never
+// answers cannot hold up the caller. Problems are logged to l, or if l is
+// nil to the standard logger with the server address attached.
+func DataSender(l *Logger, address string, data []byte, timeout time.Duration) (string,error){
+	if l == nil {
+		l = std.With("server", address)
+	}
+	deadline := time.Now().Add(timeout)
+	conn,err := net.DialTimeout("tcp",address,timeout)
 	if err != nil {
+		l.Log(Ldebug, "connect error", "err", err)
 		return "connect error:",err
 	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		l.Log(Lerror, "set deadline error", "err", err)
+		return "set deadline error:",err
+	}
+	defer func(){
+		err = conn.Close()
+		if err != nil {
+			return
+		}
+	}()
 	zbxHeader := []byte("ZBXD\x01")
 	zbxHeaderLength := len(zbxHeader)+8
 	dataLength := len(data)
@@ -31,24 +47,18 @@ func DataSender(data []byte) (string,error){
 	msgArray = append(msgArray, data...)
 	_,err = conn.Write(msgArray)
 	if err != nil {
-		log.Println("send data error:",err)
+		l.Log(Lerror, "send data error", "bytes", dataLength, "err", err)
 		return "send data error:",err
 	}
-	defer func(){
-		err = conn.Close()
-		if err != nil {
-			return
-		}
-	}()
 	var buf bytes.Buffer
 	_,err = io.Copy(&buf,conn)
 	if err != nil {
-		log.Println("error data",err)
+		l.Log(Lerror, "error data", "err", err)
 		return "error data",err
 	}
-	if string(buf.Bytes()[:5]) != "ZBXD\x01" {
-		log.Println("zabbix server:Invalid data header",err)
-		return "zabbix server:Invalid data header",err
+	if buf.Len() < zbxHeaderLength || string(buf.Bytes()[:5]) != "ZBXD\x01" {
+		l.Log(Lerror, "zabbix server:Invalid data header", "response", buf.String())
+		return "zabbix server:Invalid data header",errors.New("zabbix server: invalid data header")
 	}
 	return string(buf.Bytes())[13:],nil
 